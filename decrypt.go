@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/wryun/journalship/internal/keyring"
+)
+
+// decryptMain reverses the encrypt formatter on NDJSON (e.g. the
+// output of the file shipper), reading stdin and writing stdout.
+func decryptMain(args []string) {
+	flags := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	keyringFileName := flags.String("k", "", "keyring file used to encrypt")
+	flags.Parse(args)
+
+	if *keyringFileName == "" {
+		log.Fatal("must specify keyring file (-k)")
+	}
+	kr, err := keyring.Load(*keyringFileName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := decryptStream(kr, os.Stdin, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// decryptStream reports envelopes it can't open (e.g. because the key
+// is no longer in the keyring) and leaves them in place, so one bad
+// line doesn't stop the rest being decrypted.
+func decryptStream(kr *keyring.Keyring, in io.Reader, out io.Writer) error {
+	decoder := json.NewDecoder(bufio.NewReader(in))
+	// (so large integers aren't mangled by float64)
+	decoder.UseNumber()
+	writer := bufio.NewWriter(out)
+	defer writer.Flush()
+	encoder := json.NewEncoder(writer)
+
+	failed := 0
+	for line := 1; ; line++ {
+		var entry interface{}
+		if err := decoder.Decode(&entry); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		decrypted, errs := decryptValue(kr, entry, nil)
+		for _, err := range errs {
+			log.Printf("line %d: %s", line, err)
+		}
+		if len(errs) > 0 {
+			failed++
+		}
+		if err := encoder.Encode(decrypted); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("could not decrypt %d entries", failed)
+	}
+	return nil
+}
+
+func decryptValue(kr *keyring.Keyring, value interface{}, errs []error) (interface{}, []error) {
+	if keyring.IsEnvelope(value) {
+		opened, err := kr.Open(value)
+		if err != nil {
+			return value, append(errs, err)
+		}
+		return opened, errs
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, inner := range v {
+			v[k], errs = decryptValue(kr, inner, errs)
+		}
+	case []interface{}:
+		for i, inner := range v {
+			v[i], errs = decryptValue(kr, inner, errs)
+		}
+	}
+	return value, errs
+}
@@ -3,13 +3,60 @@ package formatters
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 
 	"github.com/wryun/journalship/internal"
 )
 
+// addValue produces a value to add given the entry's (current) fields.
+type addValue func(map[string]interface{}) (interface{}, error)
+
+// compileAddValue turns any strings containing {{ into templates
+// (see templateFuncs), leaving everything else as is.
+func compileAddValue(name string, value interface{}) (addValue, error) {
+	switch v := value.(type) {
+	case string:
+		if !strings.Contains(v, "{{") {
+			break
+		}
+		tmpl, err := compileTemplate(name, v)
+		if err != nil {
+			return nil, err
+		}
+		return func(fields map[string]interface{}) (interface{}, error) {
+			return executeTemplate(tmpl, fields)
+		}, nil
+	case map[string]interface{}:
+		inner := make(map[string]addValue, len(v))
+		for k, innerValue := range v {
+			var err error
+			if inner[k], err = compileAddValue(name+"."+k, innerValue); err != nil {
+				return nil, err
+			}
+		}
+		return func(fields map[string]interface{}) (interface{}, error) {
+			result := make(map[string]interface{}, len(inner))
+			for k, innerValue := range inner {
+				var err error
+				if result[k], err = innerValue(fields); err != nil {
+					return nil, err
+				}
+			}
+			return result, nil
+		}, nil
+	}
+	return func(map[string]interface{}) (interface{}, error) {
+		return deepCopy(value), nil
+	}, nil
+}
+
 func NewAddFormatter(rawConfig json.RawMessage) (FormatEntry, error) {
-	var config struct {
-		Fields map[string]interface{} `json:"fields"`
+	config := struct {
+		Fields    map[string]interface{} `json:"fields"`
+		Overwrite bool                   `json:"overwrite"`
+	}{
+		Overwrite: true,
 	}
 	if err := json.Unmarshal(rawConfig, &config); err != nil {
 		return nil, err
@@ -19,8 +66,29 @@ func NewAddFormatter(rawConfig json.RawMessage) (FormatEntry, error) {
 		return nil, errors.New("must specify fields to add")
 	}
 
+	values := make(map[string]addValue, len(config.Fields))
+	for k, v := range config.Fields {
+		var err error
+		if values[k], err = compileAddValue(k, v); err != nil {
+			return nil, fmt.Errorf("add field %s: %s", k, err)
+		}
+	}
+
 	return func(entry *internal.Entry) error {
-		for k, v := range config.Fields {
+		// Templates all see the fields as they were before we added
+		// anything, regardless of order.
+		results := make(map[string]interface{}, len(values))
+		for k, value := range values {
+			if _, exists := entry.Fields[k]; exists && !config.Overwrite {
+				continue
+			}
+			result, err := value(entry.Fields)
+			if err != nil {
+				return fmt.Errorf("add field %s: %s", k, err)
+			}
+			results[k] = result
+		}
+		for k, v := range results {
 			entry.Fields[k] = v
 		}
 		return nil
@@ -0,0 +1,320 @@
+package formatters
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wryun/journalship/internal"
+)
+
+var auditArchNames = map[string]string{
+	"c000003e": "x86_64",
+	"40000003": "i386",
+	"c00000b7": "aarch64",
+	"40000028": "arm",
+	"c0000015": "ppc64le",
+	"80000016": "s390x",
+	"c00000f3": "riscv64",
+}
+
+// auditHexFields are the fields the kernel hex encodes (instead of
+// quoting) if they contain anything unusual.
+var auditHexFields = map[string]bool{
+	"proctitle": true, "name": true, "cwd": true, "comm": true,
+	"exe": true, "path": true, "key": true, "data": true,
+	"acct": true, "cmd": true, "ocomm": true, "old": true, "new": true,
+}
+
+var auditExecveArg = regexp.MustCompile(`^a\d+$`)
+
+// auditEventTypes are the records which are part of a (syscall)
+// event, so can be correlated; anything else stands alone.
+var auditEventTypes = map[string]bool{
+	"SYSCALL": true, "PATH": true, "CWD": true, "EXECVE": true,
+	"PROCTITLE": true, "SOCKADDR": true, "SOCKETCALL": true, "IPC": true,
+	"MMAP": true, "FD_PAIR": true, "OBJ_PID": true, "BPRM_FCAPS": true,
+	"CAPSET": true, "AVC": true, "SELINUX_ERR": true, "KERN_MODULE": true,
+	"NETFILTER_CFG": true, "CONFIG_CHANGE": true, "EOE": true,
+}
+
+// auditListTypes can appear more than once in an event.
+var auditListTypes = map[string]bool{"PATH": true, "OBJ_PID": true, "AVC": true}
+
+// e.g. type=SYSCALL msg=audit(1364481363.243:24287): ...
+var auditHeader = regexp.MustCompile(`^(?:type=(\S+) )?msg=audit\((\d+)\.(\d+):(\d+)\):\s*`)
+
+// journald puts the type name at the start of MESSAGE instead.
+var auditTypeName = regexp.MustCompile(`^([A-Z][A-Z0-9_]*) `)
+
+func decodeAuditValue(recordType, key, value string) string {
+	if !auditHexFields[key] && !(recordType == "EXECVE" && auditExecveArg.MatchString(key)) {
+		return value
+	}
+	if len(value) == 0 || len(value)%2 != 0 {
+		return value
+	}
+	decoded, err := hex.DecodeString(value)
+	if err != nil {
+		return value
+	}
+	if key == "proctitle" {
+		// (the arguments are NUL separated)
+		return strings.TrimRight(strings.Replace(string(decoded), "\x00", " ", -1), " ")
+	}
+	return string(decoded)
+}
+
+func isAuditSpace(c byte) bool {
+	// (\x1d separates the enriched fields auditd adds)
+	return c == ' ' || c == '\x1d'
+}
+
+// parseAuditFields handles the key=value body of an audit record,
+// where values are either "quoted" (as is), hex encoded, or bare.
+// The msg='...' of userspace records is parsed as a nested record.
+func parseAuditFields(recordType, body string) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	i := 0
+	for i < len(body) {
+		if isAuditSpace(body[i]) {
+			i++
+			continue
+		}
+		start := i
+		for i < len(body) && body[i] != '=' && !isAuditSpace(body[i]) {
+			i++
+		}
+		if i == len(body) || body[i] != '=' {
+			// (stray words, which we ignore)
+			continue
+		}
+		key := body[start:i]
+		i++
+
+		if i < len(body) && (body[i] == '"' || body[i] == '\'') {
+			quote := body[i]
+			value, end, err := parseQuoted(body, i)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+			if quote == '\'' && key == "msg" {
+				nested, err := parseAuditFields(recordType, value)
+				if err != nil {
+					return nil, err
+				}
+				fields[key] = nested
+			} else {
+				fields[key] = value
+			}
+			continue
+		}
+		start = i
+		for i < len(body) && !isAuditSpace(body[i]) {
+			i++
+		}
+		fields[key] = decodeAuditValue(recordType, key, body[start:i])
+	}
+
+	if arch, ok := fields["arch"].(string); ok {
+		if archName, ok := auditArchNames[arch]; ok {
+			fields["archName"] = archName
+			if number, ok := fields["syscall"].(string); ok {
+				if n, err := strconv.Atoi(number); err == nil {
+					if name, ok := auditSyscalls[archName][n]; ok {
+						fields["syscallName"] = name
+					}
+				}
+			}
+		}
+	}
+	return fields, nil
+}
+
+type auditRecord struct {
+	recordType string
+	serial     int64
+	time       string
+	fields     map[string]interface{}
+}
+
+func parseAuditRecord(message string, entryFields map[string]interface{}) (*auditRecord, error) {
+	record := &auditRecord{}
+	body := message
+	if match := auditHeader.FindStringSubmatch(message); match != nil {
+		record.recordType = match[1]
+		seconds, _ := strconv.ParseInt(match[2], 10, 64)
+		millis, _ := strconv.ParseInt(match[3], 10, 64)
+		record.time = time.Unix(seconds, millis*int64(time.Millisecond)).UTC().Format(time.RFC3339Nano)
+		record.serial, _ = strconv.ParseInt(match[4], 10, 64)
+		body = message[len(match[0]):]
+	} else if match := auditTypeName.FindStringSubmatch(message); match != nil {
+		record.recordType = match[1]
+		body = message[len(match[0]):]
+		if id, ok := entryFields["_AUDIT_ID"]; ok {
+			record.serial, _ = strconv.ParseInt(toString(id), 10, 64)
+		}
+	} else {
+		return nil, errors.New("not an audit record")
+	}
+	if name, ok := entryFields["_AUDIT_TYPE_NAME"].(string); ok && name != "" {
+		record.recordType = name
+	}
+	if record.recordType == "" {
+		return nil, errors.New("audit record without a type")
+	}
+
+	var err error
+	record.fields, err = parseAuditFields(record.recordType, body)
+	return record, err
+}
+
+type auditEvent struct {
+	started time.Time
+	base    map[string]interface{}
+	records []*auditRecord
+}
+
+func (e *auditEvent) describe() map[string]interface{} {
+	first := e.records[0]
+	result := map[string]interface{}{
+		"serial": first.serial,
+		"type":   first.recordType,
+	}
+	if first.time != "" {
+		result["time"] = first.time
+	}
+	for _, record := range e.records {
+		if record.recordType == "EOE" {
+			continue
+		}
+		name := strings.ToLower(record.recordType)
+		if auditListTypes[record.recordType] {
+			list, _ := result[name].([]interface{})
+			result[name] = append(list, record.fields)
+		} else {
+			result[name] = record.fields
+		}
+	}
+	return result
+}
+
+// NewAuditFormatter parses the records of the Linux audit system
+// (_TRANSPORT=audit, or the raw type=... msg=audit(...) format) into
+// fields, decoding hex encoded values and naming syscalls.
+//
+// If correlate is set, the records of a syscall event (which share a
+// serial) are held back and combined into a single entry when the
+// event ends (EOE) or the window expires. Like dedup, expiry is only
+// checked when another entry arrives. Held records count as shipped
+// as far as the cursor is concerned, so are lost if journalship stops
+// before the event is complete.
+func NewAuditFormatter(rawConfig json.RawMessage) (FormatEntry, error) {
+	config := struct {
+		errorPolicyConfig
+		InputPath    string `json:"inputPath"`
+		OutputPath   string `json:"outputPath"`
+		Transport    string `json:"transport"`
+		KeepOriginal bool   `json:"keepOriginal"`
+		Correlate    bool   `json:"correlate"`
+		Window       int    `json:"window"`
+	}{
+		InputPath:  "MESSAGE",
+		OutputPath: "audit",
+		Transport:  "audit",
+		Window:     2,
+	}
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, err
+	}
+
+	if config.InputPath == "" || config.OutputPath == "" {
+		return nil, errors.New("must specify inputPath and outputPath for audit")
+	}
+	if config.Correlate && config.Window <= 0 {
+		return nil, errors.New("audit window must be positive")
+	}
+	onError, err := newErrorPolicy("audit", config.errorPolicyConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	inputPath := parseFieldPath(config.InputPath)
+	outputPath := parseFieldPath(config.OutputPath)
+	window := time.Duration(config.Window) * time.Second
+	counts := counters("audit")
+
+	var mutex sync.Mutex
+	events := make(map[int64]*auditEvent)
+
+	finish := func(entry *internal.Entry, event *auditEvent) {
+		fields := event.base
+		if !config.KeepOriginal {
+			inputPath.delete(fields)
+		}
+		outputPath.set(fields, event.describe())
+		counts.Add("correlated", 1)
+		entry.Emit(fields)
+	}
+
+	return func(entry *internal.Entry) error {
+		if config.Transport != "" && entry.Fields["_TRANSPORT"] != config.Transport {
+			return nil
+		}
+		value, ok := inputPath.get(entry.Fields)
+		if !ok {
+			return nil
+		}
+		message, ok := value.(string)
+		if !ok {
+			return nil
+		}
+
+		record, err := parseAuditRecord(message, entry.Fields)
+		if err != nil {
+			counts.Add("errors", 1)
+			return onError.handle(entry, err)
+		}
+		counts.Add("records", 1)
+
+		if !config.Correlate || !auditEventTypes[record.recordType] || record.serial == 0 {
+			if !config.KeepOriginal {
+				inputPath.delete(entry.Fields)
+			}
+			outputPath.set(entry.Fields, (&auditEvent{records: []*auditRecord{record}}).describe())
+			return nil
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		now := time.Now()
+		for serial, event := range events {
+			if now.Sub(event.started) > window && serial != record.serial {
+				delete(events, serial)
+				finish(entry, event)
+			}
+		}
+
+		event, ok := events[record.serial]
+		if !ok {
+			event = &auditEvent{started: now, base: entry.Fields}
+			events[record.serial] = event
+		}
+		event.records = append(event.records, record)
+		// The record lives on in the event, so this entry goes.
+		entry.Fields = nil
+
+		if record.recordType == "EOE" {
+			delete(events, record.serial)
+			finish(entry, event)
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,210 @@
+package formatters
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/wryun/journalship/internal"
+)
+
+// dockerContainer is the part of both the Engine API's container
+// inspect response and config.v2.json that we care about.
+type dockerContainer struct {
+	ID     string `json:"ID"`
+	Name   string `json:"Name"`
+	Image  string `json:"Image"`
+	Config struct {
+		Image  string            `json:"Image"`
+		Labels map[string]string `json:"Labels"`
+	} `json:"Config"`
+}
+
+// containerIDRegexp is what docker ids look like (full or short).
+// Anything can be put in the journal's CONTAINER_ fields, so ids are
+// checked before going anywhere near a URL or a path.
+var containerIDRegexp = regexp.MustCompile(`^[0-9a-f]{12,64}$`)
+
+type containerLookup func(id string) (*dockerContainer, error)
+
+func newDockerAPILookup(socket string, timeout time.Duration) containerLookup {
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	return func(id string) (*dockerContainer, error) {
+		resp, err := client.Get("http://docker/containers/" + id + "/json")
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, nil
+		} else if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("docker API returned %s for %s", resp.Status, id)
+		}
+		var container dockerContainer
+		err = json.NewDecoder(resp.Body).Decode(&container)
+		return &container, err
+	}
+}
+
+func newDockerFilesLookup(containersDir string) containerLookup {
+	return func(id string) (*dockerContainer, error) {
+		contents, err := ioutil.ReadFile(filepath.Join(containersDir, id, "config.v2.json"))
+		if err != nil {
+			return nil, nil
+		}
+		var container dockerContainer
+		err = json.Unmarshal(contents, &container)
+		return &container, err
+	}
+}
+
+// matchesAny reports whether name matches any of the glob patterns.
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// NewContainerFormatter adds the image and labels of the container
+// an entry came from (looked up via the docker API or docker's
+// files on disk, and cached).
+func NewContainerFormatter(rawConfig json.RawMessage) (FormatEntry, error) {
+	config := struct {
+		IDField       string   `json:"idField"`
+		OutputPath    string   `json:"outputPath"`
+		Source        string   `json:"source"`
+		Socket        string   `json:"socket"`
+		ContainersDir string   `json:"containersDir"`
+		Timeout       int      `json:"timeout"`
+		CacheSize     int      `json:"cacheSize"`
+		CacheTTL      int      `json:"cacheTTL"`
+		LabelAllow    []string `json:"labelAllow"`
+		LabelDeny     []string `json:"labelDeny"`
+	}{
+		IDField:       "CONTAINER_ID_FULL",
+		OutputPath:    "container",
+		Source:        "api",
+		Socket:        "/var/run/docker.sock",
+		ContainersDir: "/var/lib/docker/containers",
+		Timeout:       2,
+		CacheSize:     1000,
+		CacheTTL:      300,
+	}
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, err
+	}
+
+	var lookup containerLookup
+	switch config.Source {
+	case "api":
+		lookup = newDockerAPILookup(config.Socket, time.Duration(config.Timeout)*time.Second)
+	case "files":
+		lookup = newDockerFilesLookup(config.ContainersDir)
+	default:
+		return nil, fmt.Errorf("unknown container source %q", config.Source)
+	}
+	if config.OutputPath == "" {
+		return nil, errors.New("must specify outputPath for container")
+	}
+	if config.CacheSize <= 0 {
+		return nil, errors.New("container cacheSize must be positive")
+	}
+
+	idPath := parseFieldPath(config.IDField)
+	outputPath := parseFieldPath(config.OutputPath)
+	cache := newLRUCache(config.CacheSize, time.Duration(config.CacheTTL)*time.Second)
+	counts := counters("container")
+
+	describe := func(container *dockerContainer) map[string]interface{} {
+		info := map[string]interface{}{
+			"id":      container.ID,
+			"name":    strings.TrimPrefix(container.Name, "/"),
+			"image":   container.Config.Image,
+			"imageID": container.Image,
+		}
+		labels := make(map[string]interface{})
+		for k, v := range container.Config.Labels {
+			if (len(config.LabelAllow) == 0 || matchesAny(config.LabelAllow, k)) && !matchesAny(config.LabelDeny, k) {
+				labels[k] = v
+			}
+		}
+		info["labels"] = labels
+
+		// These are always useful, so are pulled out regardless of
+		// the allow/deny lists.
+		all := container.Config.Labels
+		if project, ok := all["com.docker.compose.project"]; ok {
+			info["compose"] = map[string]interface{}{
+				"project": project,
+				"service": all["com.docker.compose.service"],
+			}
+		}
+		if pod, ok := all["io.kubernetes.pod.name"]; ok {
+			info["kubernetes"] = map[string]interface{}{
+				"pod":       pod,
+				"namespace": all["io.kubernetes.pod.namespace"],
+				"container": all["io.kubernetes.container.name"],
+			}
+		}
+		return info
+	}
+
+	return func(entry *internal.Entry) error {
+		value, ok := idPath.get(entry.Fields)
+		if !ok {
+			return nil
+		}
+		id, ok := value.(string)
+		if !ok || id == "" {
+			return nil
+		}
+		if !containerIDRegexp.MatchString(id) {
+			counts.Add("invalidIDs", 1)
+			return fmt.Errorf("container: invalid container id %q", id)
+		}
+
+		cached, ok := cache.get(id)
+		if !ok {
+			counts.Add("lookups", 1)
+			container, err := lookup(id)
+			if err != nil {
+				// Not cached, so we'll try again next time.
+				counts.Add("errors", 1)
+				return fmt.Errorf("container: %s", err)
+			}
+			// Missing containers are cached too (as nil) so we don't
+			// keep asking about them.
+			if container == nil {
+				cached = map[string]interface{}(nil)
+			} else {
+				cached = describe(container)
+			}
+			cache.put(id, cached)
+		}
+
+		if info := cached.(map[string]interface{}); info != nil {
+			outputPath.set(entry.Fields, deepCopy(info))
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,219 @@
+package formatters
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wryun/journalship/internal"
+)
+
+type convertFn func(interface{}) (interface{}, error)
+
+// journalSchema covers the well-known journal fields which aren't
+// really strings (see systemd.journal-fields(7)).
+var journalSchema = map[string]string{
+	"PRIORITY":                    "int",
+	"SYSLOG_FACILITY":             "int",
+	"SYSLOG_PID":                  "int",
+	"ERRNO":                       "int",
+	"CODE_LINE":                   "int",
+	"_PID":                        "int",
+	"_UID":                        "int",
+	"_GID":                        "int",
+	"_AUDIT_SESSION":              "int",
+	"_AUDIT_LOGINUID":             "int",
+	"_SOURCE_MONOTONIC_TIMESTAMP": "int",
+	"_SOURCE_REALTIME_TIMESTAMP":  "timestamp:epochMicros",
+	"COREDUMP_PID":                "int",
+	"COREDUMP_UID":                "int",
+	"COREDUMP_GID":                "int",
+	"COREDUMP_SIGNAL":             "int",
+	"CONTAINER_PARTIAL_MESSAGE":   "bool",
+}
+
+func convertInt(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	case float64:
+		if v != math.Trunc(v) {
+			return nil, fmt.Errorf("%v is not an integer", v)
+		}
+		return int64(v), nil
+	case int64, int:
+		return v, nil
+	}
+	return nil, fmt.Errorf("unable to convert %T to int", value)
+}
+
+func convertFloat(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(v), 64)
+	case float64:
+		return v, nil
+	case int64:
+		return float64(v), nil
+	}
+	return nil, fmt.Errorf("unable to convert %T to float", value)
+}
+
+func convertBool(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return strconv.ParseBool(strings.TrimSpace(v))
+	case bool:
+		return v, nil
+	}
+	return nil, fmt.Errorf("unable to convert %T to bool", value)
+}
+
+// convertDuration produces (fractional) seconds, since that's what
+// most consumers can do arithmetic on.
+func convertDuration(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		d, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil {
+			return nil, err
+		}
+		return d.Seconds(), nil
+	case float64:
+		return v, nil
+	}
+	return nil, fmt.Errorf("unable to convert %T to duration", value)
+}
+
+func convertString(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case map[string]interface{}, []interface{}:
+		raw, err := json.Marshal(v)
+		return string(raw), err
+	}
+	return fmt.Sprint(value), nil
+}
+
+func convertJSON(value interface{}) (interface{}, error) {
+	s, ok := value.(string)
+	if !ok {
+		return value, nil
+	}
+	var result interface{}
+	err := json.Unmarshal([]byte(s), &result)
+	return result, err
+}
+
+func newConvertFn(typeName string) (convertFn, error) {
+	switch typeName {
+	case "int":
+		return convertInt, nil
+	case "float":
+		return convertFloat, nil
+	case "bool":
+		return convertBool, nil
+	case "duration":
+		return convertDuration, nil
+	case "string":
+		return convertString, nil
+	case "json":
+		return convertJSON, nil
+	}
+
+	// timestamp or timestamp:<input format> (output is always RFC3339Nano)
+	if typeName == "timestamp" || strings.HasPrefix(typeName, "timestamp:") {
+		format := strings.TrimPrefix(strings.TrimPrefix(typeName, "timestamp"), ":")
+		if format == "" {
+			format = "RFC3339Nano"
+		}
+		tf, err := parseTimeFormat(format)
+		if err != nil {
+			return nil, err
+		}
+		return func(value interface{}) (interface{}, error) {
+			t, err := tf.parse(value, time.UTC)
+			if err != nil {
+				return nil, err
+			}
+			return t.UTC().Format(time.RFC3339Nano), nil
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown type %q", typeName)
+}
+
+func NewConvertFormatter(rawConfig json.RawMessage) (FormatEntry, error) {
+	var config struct {
+		Fields        map[string]string `json:"fields"`
+		JournalSchema bool              `json:"journalSchema"`
+		errorPolicyConfig
+	}
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, err
+	}
+
+	schema := make(map[string]string)
+	if config.JournalSchema {
+		for k, v := range journalSchema {
+			schema[k] = v
+		}
+	}
+	for k, v := range config.Fields {
+		schema[k] = v
+	}
+	if len(schema) == 0 {
+		return nil, errors.New("must specify fields to convert (or journalSchema)")
+	}
+
+	type conversion struct {
+		path    fieldPath
+		convert convertFn
+	}
+	paths := make([]string, 0, len(schema))
+	for path := range schema {
+		paths = append(paths, path)
+	}
+	// sorted so any errors are reported consistently
+	sort.Strings(paths)
+	conversions := make([]conversion, 0, len(schema))
+	for _, path := range paths {
+		typeName := schema[path]
+		convert, err := newConvertFn(typeName)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %s", path, err)
+		}
+		conversions = append(conversions, conversion{parseFieldPath(path), convert})
+	}
+
+	onError, err := newErrorPolicy("convert", config.errorPolicyConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(entry *internal.Entry) error {
+		var errs []string
+		for _, c := range conversions {
+			value, ok := c.path.get(entry.Fields)
+			if !ok {
+				continue
+			}
+			converted, err := c.convert(value)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", c.path, err))
+				continue
+			}
+			c.path.set(entry.Fields, converted)
+		}
+		if errs != nil {
+			return onError.handle(entry, errors.New(strings.Join(errs, "; ")))
+		}
+		return nil
+	}, nil
+}
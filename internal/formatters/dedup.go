@@ -0,0 +1,139 @@
+package formatters
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/wryun/journalship/internal"
+)
+
+// dedupSweepInterval limits how often every run is checked for
+// expiry (which happens with the lock held).
+const dedupSweepInterval = time.Second
+
+type dedupRun struct {
+	started    time.Time
+	match      string
+	suppressed int
+	firstTime  interface{}
+	lastTime   interface{}
+	last       map[string]interface{}
+}
+
+// NewDedupFormatter suppresses repeats of the same message from the
+// same source (by default, the same MESSAGE from the same unit),
+// replacing them with a 'last message repeated N times' summary.
+//
+// The summary is emitted when a different message arrives from
+// that source, or when a repeat arrives after the window has expired.
+// Idle sources are also checked (at most once a second) when other
+// entries arrive, so a summary is delayed (at worst) until something
+// else is logged.
+func NewDedupFormatter(rawConfig json.RawMessage) (FormatEntry, error) {
+	config := struct {
+		GroupFields  []string `json:"groupFields"`
+		MatchFields  []string `json:"matchFields"`
+		Window       int      `json:"window"`
+		MessageField string   `json:"messageField"`
+		TimeField    string   `json:"timeField"`
+		SummaryPath  string   `json:"summaryPath"`
+	}{
+		GroupFields:  []string{"_SYSTEMD_UNIT"},
+		MatchFields:  []string{"MESSAGE"},
+		Window:       30,
+		MessageField: "MESSAGE",
+		TimeField:    "TIME",
+		SummaryPath:  "repeated",
+	}
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, err
+	}
+
+	if len(config.MatchFields) == 0 {
+		return nil, errors.New("must specify matchFields for dedup")
+	}
+	if config.Window <= 0 {
+		return nil, errors.New("dedup window must be positive")
+	}
+
+	groupPaths := make([]fieldPath, 0, len(config.GroupFields))
+	for _, field := range config.GroupFields {
+		groupPaths = append(groupPaths, parseFieldPath(field))
+	}
+	matchPaths := make([]fieldPath, 0, len(config.MatchFields))
+	for _, field := range config.MatchFields {
+		matchPaths = append(matchPaths, parseFieldPath(field))
+	}
+	window := time.Duration(config.Window) * time.Second
+	messagePath := parseFieldPath(config.MessageField)
+	timePath := parseFieldPath(config.TimeField)
+	summaryPath := parseFieldPath(config.SummaryPath)
+	counts := counters("dedup")
+
+	var mutex sync.Mutex
+	runs := make(map[string]*dedupRun)
+	var lastSweep time.Time
+
+	// The summary looks like the last suppressed entry (so it ends up
+	// wherever that would have), apart from the message.
+	summarise := func(entry *internal.Entry, run *dedupRun) {
+		fields := make(map[string]interface{}, len(run.last)+1)
+		for k, v := range run.last {
+			fields[k] = v
+		}
+		original, _ := messagePath.get(run.last)
+		messagePath.set(fields, fmt.Sprintf("last message repeated %d times", run.suppressed))
+		summaryPath.set(fields, map[string]interface{}{
+			"count":     run.suppressed,
+			"firstTime": run.firstTime,
+			"lastTime":  run.lastTime,
+			"message":   original,
+		})
+		entry.Emit(fields)
+		counts.Add("summaries", 1)
+	}
+
+	return func(entry *internal.Entry) error {
+		now := time.Now()
+		group := joinValues(entry.Fields, groupPaths)
+		match := joinValues(entry.Fields, matchPaths)
+		entryTime, _ := timePath.get(entry.Fields)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if now.Sub(lastSweep) >= dedupSweepInterval {
+			lastSweep = now
+			for otherGroup, run := range runs {
+				if otherGroup != group && now.Sub(run.started) > window {
+					if run.suppressed > 0 {
+						summarise(entry, run)
+					}
+					delete(runs, otherGroup)
+				}
+			}
+		}
+
+		run, ok := runs[group]
+		if ok && run.match == match && now.Sub(run.started) <= window {
+			if run.suppressed == 0 {
+				run.firstTime = entryTime
+			}
+			run.suppressed++
+			run.lastTime = entryTime
+			run.last = entry.Fields
+			entry.Fields = nil
+			counts.Add("suppressed", 1)
+			return nil
+		}
+
+		if ok && run.suppressed > 0 {
+			summarise(entry, run)
+		}
+		runs[group] = &dedupRun{started: now, match: match}
+		return nil
+	}, nil
+}
@@ -0,0 +1,55 @@
+package formatters
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/wryun/journalship/internal"
+	"github.com/wryun/journalship/internal/keyring"
+)
+
+// NewEncryptFormatter replaces each of the configured fields with an
+// encrypted envelope, which 'journalship decrypt' can reverse.
+func NewEncryptFormatter(rawConfig json.RawMessage) (FormatEntry, error) {
+	var config struct {
+		Fields      []string `json:"fields"`
+		KeyringFile string   `json:"keyringFile"`
+	}
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, err
+	}
+
+	if len(config.Fields) == 0 {
+		return nil, errors.New("must specify fields to encrypt")
+	}
+	if config.KeyringFile == "" {
+		return nil, errors.New("must specify keyringFile")
+	}
+	kr, err := keyring.Load(config.KeyringFile)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]fieldPath, 0, len(config.Fields))
+	for _, field := range config.Fields {
+		paths = append(paths, parseFieldPath(field))
+	}
+
+	return func(entry *internal.Entry) error {
+		for _, path := range paths {
+			value, ok := path.get(entry.Fields)
+			if !ok {
+				continue
+			}
+			envelope, err := kr.Seal(value)
+			if err != nil {
+				// Never ship the plaintext if something's gone wrong.
+				entry.Fields = nil
+				return fmt.Errorf("unable to encrypt %s (dropped entry): %s", path, err)
+			}
+			path.set(entry.Fields, envelope)
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,119 @@
+package formatters
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/wryun/journalship/internal"
+)
+
+// errorPolicyConfig is embedded in the config of formatters which
+// can fail on individual entries, so they all handle failure the
+// same way.
+type errorPolicyConfig struct {
+	OnError        string `json:"onError"`
+	ErrorField     string `json:"errorField"`
+	DeadLetterFile string `json:"deadLetterFile"`
+}
+
+type errorPolicy struct {
+	formatter  string
+	onError    string
+	errorField fieldPath
+	deadLetter *deadLetterFile
+}
+
+func newErrorPolicy(formatter string, config errorPolicyConfig) (*errorPolicy, error) {
+	policy := &errorPolicy{
+		formatter:  formatter,
+		onError:    config.OnError,
+		errorField: parseFieldPath(config.ErrorField),
+	}
+	if policy.onError == "" {
+		policy.onError = "ignore"
+	}
+	if policy.errorField == nil {
+		policy.errorField = fieldPath{"error"}
+	}
+
+	switch policy.onError {
+	case "ignore", "log", "tag", "drop":
+	case "deadLetter":
+		if config.DeadLetterFile == "" {
+			return nil, fmt.Errorf("%s: must specify deadLetterFile when onError is deadLetter", formatter)
+		}
+		var err error
+		if policy.deadLetter, err = openDeadLetterFile(config.DeadLetterFile); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%s: unknown onError policy %q", formatter, policy.onError)
+	}
+	return policy, nil
+}
+
+// handle applies the policy to an entry the formatter failed on,
+// returning an error only if it should be logged by the transformer.
+func (p *errorPolicy) handle(entry *internal.Entry, err error) error {
+	switch p.onError {
+	case "log":
+		return fmt.Errorf("%s: %s", p.formatter, err)
+	case "tag":
+		p.errorField.set(entry.Fields, fmt.Sprintf("%s: %s", p.formatter, err))
+	case "drop":
+		entry.Fields = nil
+	case "deadLetter":
+		if dlErr := p.deadLetter.write(p.formatter, entry, err); dlErr != nil {
+			return fmt.Errorf("%s: unable to write to dead letter file: %s (original error: %s)", p.formatter, dlErr, err)
+		}
+		entry.Fields = nil
+	}
+	return nil
+}
+
+// deadLetterFile receives (as NDJSON) entries that formatters have
+// diverted from the normal output, along with why.
+type deadLetterFile struct {
+	mutex sync.Mutex
+	out   *os.File
+}
+
+var (
+	deadLetterFilesMutex sync.Mutex
+	deadLetterFiles      = map[string]*deadLetterFile{}
+)
+
+func openDeadLetterFile(fileName string) (*deadLetterFile, error) {
+	deadLetterFilesMutex.Lock()
+	defer deadLetterFilesMutex.Unlock()
+
+	if dl, ok := deadLetterFiles[fileName]; ok {
+		return dl, nil
+	}
+	out, err := os.OpenFile(fileName, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	dl := &deadLetterFile{out: out}
+	deadLetterFiles[fileName] = dl
+	return dl, nil
+}
+
+func (dl *deadLetterFile) write(formatter string, entry *internal.Entry, reason error) error {
+	raw, err := json.Marshal(map[string]interface{}{
+		"time":      time.Now().Format(time.RFC3339Nano),
+		"formatter": formatter,
+		"error":     reason.Error(),
+		"fields":    entry.Fields,
+	})
+	if err != nil {
+		return err
+	}
+	dl.mutex.Lock()
+	defer dl.mutex.Unlock()
+	_, err = dl.out.Write(append(raw, '\n'))
+	return err
+}
@@ -0,0 +1,267 @@
+package formatters
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/wryun/journalship/internal"
+)
+
+// execProcess is a running instance of the exec formatter's command.
+type execProcess struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+func startExecProcess(command []string) (*execProcess, error) {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stderr = os.Stderr
+	// In its own process group, so that stop gets anything it starts
+	// too (e.g. when it's a shell script).
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &execProcess{cmd: cmd, stdin: stdin, stdout: bufio.NewReader(stdout)}, nil
+}
+
+func (p *execProcess) stop() {
+	p.stdin.Close()
+	syscall.Kill(-p.cmd.Process.Pid, syscall.SIGKILL)
+	p.cmd.Wait()
+}
+
+// exchange writes one line per entry and reads one line back for
+// each (writing happens separately so neither side can block the
+// other on a full pipe).
+func (p *execProcess) exchange(lines [][]byte) ([][]byte, error) {
+	writeErr := make(chan error, 1)
+	go func() {
+		for _, line := range lines {
+			if _, err := p.stdin.Write(line); err != nil {
+				writeErr <- err
+				return
+			}
+		}
+		writeErr <- nil
+	}()
+
+	responses := make([][]byte, len(lines))
+	for i := range responses {
+		response, err := p.stdout.ReadBytes('\n')
+		if err != nil {
+			return nil, fmt.Errorf("reading response: %s", err)
+		}
+		responses[i] = response
+	}
+	if err := <-writeErr; err != nil {
+		return nil, fmt.Errorf("writing entries: %s", err)
+	}
+	return responses, nil
+}
+
+// applyExecResponse handles the subprocess' reply for one entry,
+// which is either its new fields, null (to drop it) or a list of
+// fields (to turn it into several entries).
+func applyExecResponse(entry *internal.Entry, response []byte) error {
+	var result interface{}
+	if err := json.Unmarshal(response, &result); err != nil {
+		return err
+	}
+	switch v := result.(type) {
+	case nil:
+		entry.Fields = nil
+	case map[string]interface{}:
+		entry.Fields = v
+	case []interface{}:
+		all := make([]map[string]interface{}, len(v))
+		for i, inner := range v {
+			fields, ok := inner.(map[string]interface{})
+			if !ok {
+				return errors.New("list contains a non-object")
+			}
+			all[i] = fields
+		}
+		if len(all) == 0 {
+			entry.Fields = nil
+			return nil
+		}
+		// (emitted entries are shipped first, so order is preserved)
+		for _, fields := range all[:len(all)-1] {
+			entry.Emit(fields)
+		}
+		entry.Fields = all[len(all)-1]
+	default:
+		return fmt.Errorf("expected object, list or null, not %T", result)
+	}
+	return nil
+}
+
+// NewExecFormatter sends each InputChunk's entries (as NDJSON) to a
+// long running command, which must write exactly one line back for
+// each one. If the command dies or takes longer than timeout, it's
+// restarted (no more often than restartDelay); while it's unhealthy,
+// entries are passed through unchanged, dropped, or held up until
+// it's working again, depending on onUnhealthy.
+//
+// The command is shared by all the transformers, so only handles one
+// batch at a time.
+func NewExecFormatter(rawConfig json.RawMessage) (FormatBatch, error) {
+	config := struct {
+		Command      []string `json:"command"`
+		Timeout      int      `json:"timeout"`
+		RestartDelay int      `json:"restartDelay"`
+		OnUnhealthy  string   `json:"onUnhealthy"`
+	}{
+		Timeout:      5,
+		RestartDelay: 1,
+		OnUnhealthy:  "pass",
+	}
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, err
+	}
+
+	if len(config.Command) == 0 {
+		return nil, errors.New("must specify command for exec")
+	}
+	switch config.OnUnhealthy {
+	case "pass", "drop", "block":
+	default:
+		return nil, fmt.Errorf("unknown exec onUnhealthy policy %q", config.OnUnhealthy)
+	}
+	if config.Timeout <= 0 || config.RestartDelay <= 0 {
+		return nil, errors.New("exec timeout and restartDelay must be positive")
+	}
+	timeout := time.Duration(config.Timeout) * time.Second
+	restartDelay := time.Duration(config.RestartDelay) * time.Second
+	counts := counters("exec")
+
+	var (
+		mutex       sync.Mutex
+		process     *execProcess
+		lastFailure time.Time
+	)
+
+	fail := func(err error) error {
+		counts.Add("failures", 1)
+		if process != nil {
+			process.stop()
+			process = nil
+		}
+		lastFailure = time.Now()
+		return fmt.Errorf("exec %s: %s", config.Command[0], err)
+	}
+
+	// send must be called with the mutex held.
+	send := func(lines [][]byte) ([][]byte, error) {
+		if process == nil {
+			if wait := restartDelay - time.Since(lastFailure); wait > 0 {
+				if config.OnUnhealthy != "block" {
+					return nil, fmt.Errorf("exec %s: waiting to restart", config.Command[0])
+				}
+				time.Sleep(wait)
+			}
+			var err error
+			if process, err = startExecProcess(config.Command); err != nil {
+				return nil, fail(err)
+			}
+			counts.Add("starts", 1)
+		}
+
+		type result struct {
+			responses [][]byte
+			err       error
+		}
+		done := make(chan result, 1)
+		p := process
+		go func() {
+			responses, err := p.exchange(lines)
+			done <- result{responses, err}
+		}()
+		select {
+		case r := <-done:
+			if r.err != nil {
+				return nil, fail(r.err)
+			}
+			return r.responses, nil
+		case <-time.After(timeout):
+			counts.Add("timeouts", 1)
+			err := fail(fmt.Errorf("no response after %s", timeout))
+			// (killing the process means exchange will finish)
+			<-done
+			return nil, err
+		}
+	}
+
+	return func(entries []*internal.Entry) error {
+		lines := make([][]byte, 0, len(entries))
+		sent := make([]*internal.Entry, 0, len(entries))
+		for _, entry := range entries {
+			line, err := json.Marshal(entry.Fields)
+			if err != nil {
+				log.Printf("exec %s: not sending entry: %s", config.Command[0], err)
+				continue
+			}
+			lines = append(lines, append(line, '\n'))
+			sent = append(sent, entry)
+		}
+		if len(lines) == 0 {
+			return nil
+		}
+
+		mutex.Lock()
+		responses, err := send(lines)
+		for err != nil && config.OnUnhealthy == "block" {
+			log.Println(err)
+			responses, err = send(lines)
+		}
+		mutex.Unlock()
+
+		if err != nil {
+			if config.OnUnhealthy == "drop" {
+				counts.Add("dropped", int64(len(sent)))
+				for _, entry := range sent {
+					entry.Fields = nil
+				}
+			} else {
+				counts.Add("passed", int64(len(sent)))
+			}
+			return err
+		}
+
+		counts.Add("batches", 1)
+		var invalid int
+		var firstErr error
+		for i, entry := range sent {
+			if err := applyExecResponse(entry, responses[i]); err != nil {
+				invalid++
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+		}
+		if invalid > 0 {
+			counts.Add("invalidResponses", int64(invalid))
+			return fmt.Errorf("exec %s: %d invalid responses (first: %s)", config.Command[0], invalid, firstErr)
+		}
+		return nil
+	}, nil
+}
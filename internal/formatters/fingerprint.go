@@ -0,0 +1,105 @@
+package formatters
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"log"
+	"sync"
+
+	"github.com/wryun/journalship/internal"
+)
+
+var fingerprintHashes = map[string]func() hash.Hash{
+	"sha256":  sha256.New,
+	"sha1":    sha1.New,
+	"md5":     md5.New,
+	"fnv64a":  func() hash.Hash { return fnv.New64a() },
+	"fnv128a": fnv.New128a,
+}
+
+// NewFingerprintFormatter adds an id which is the same every time an
+// entry is read, so that replays (e.g. after a restart before the
+// cursor was saved) can be deduplicated downstream.
+//
+// By default the id comes from the journal cursor, which needs the
+// reader's entryMetadata option (see NeedsEntryMetadata). Entries
+// without one (e.g. those emitted by other formatters) are left
+// without an id and counted, rather than hashing their content
+// (which would give every repeat of a message the same id).
+// Alternatively, fields lists what to hash instead of the cursor.
+// NeedsEntryMetadata reports whether a formatter's config relies on
+// the reader's entryMetadata option (i.e. it's a fingerprint formatter
+// using the cursor), so it can be checked at startup.
+func NeedsEntryMetadata(rawConfig json.RawMessage) bool {
+	var config struct {
+		Type   string   `json:"type"`
+		Fields []string `json:"fields"`
+	}
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return false
+	}
+	return config.Type == "fingerprint" && len(config.Fields) == 0
+}
+
+func NewFingerprintFormatter(rawConfig json.RawMessage) (FormatEntry, error) {
+	config := struct {
+		Fields     []string `json:"fields"`
+		Hash       string   `json:"hash"`
+		Length     int      `json:"length"`
+		OutputPath string   `json:"outputPath"`
+	}{
+		Hash:       "sha256",
+		OutputPath: "fingerprint",
+	}
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, err
+	}
+
+	newHash, ok := fingerprintHashes[config.Hash]
+	if !ok {
+		return nil, fmt.Errorf("unknown fingerprint hash %q", config.Hash)
+	}
+	if config.OutputPath == "" {
+		return nil, errors.New("must specify outputPath for fingerprint")
+	}
+	if config.Length < 0 {
+		return nil, errors.New("fingerprint length must not be negative")
+	}
+
+	var paths []fieldPath
+	for _, field := range config.Fields {
+		paths = append(paths, parseFieldPath(field))
+	}
+	outputPath := parseFieldPath(config.OutputPath)
+	counts := counters("fingerprint")
+	var warnOnce sync.Once
+
+	return func(entry *internal.Entry) error {
+		h := newHash()
+		switch {
+		case paths != nil:
+			h.Write([]byte(joinValues(entry.Fields, paths)))
+		case entry.Cursor != "":
+			h.Write([]byte(entry.Cursor))
+		default:
+			counts.Add("withoutCursor", 1)
+			warnOnce.Do(func() {
+				log.Println("fingerprint: leaving entries without a cursor (e.g. from other formatters) without an id")
+			})
+			return nil
+		}
+		id := hex.EncodeToString(h.Sum(nil))
+		if config.Length > 0 && config.Length < len(id) {
+			id = id[:config.Length]
+		}
+		outputPath.set(entry.Fields, id)
+		return nil
+	}, nil
+}
@@ -0,0 +1,249 @@
+package formatters
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/wryun/journalship/internal"
+)
+
+type flattener struct {
+	separator string
+	maxDepth  int
+	arrays    string
+}
+
+// flatten adds the leaves of value to result (keys joined with the
+// separator), stopping at maxDepth.
+func (f *flattener) flatten(result map[string]interface{}, prefix string, depth int, value interface{}) error {
+	if f.maxDepth > 0 && depth >= f.maxDepth && prefix != "" {
+		result[prefix] = value
+		return nil
+	}
+	join := func(k string) string {
+		if prefix == "" {
+			return k
+		}
+		return prefix + f.separator + k
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 && prefix != "" {
+			result[prefix] = v
+		}
+		for k, inner := range v {
+			if err := f.flatten(result, join(k), depth+1, inner); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []interface{}:
+		switch f.arrays {
+		case "index":
+			if len(v) == 0 {
+				result[prefix] = v
+			}
+			for i, inner := range v {
+				if err := f.flatten(result, join(strconv.Itoa(i)), depth+1, inner); err != nil {
+					return err
+				}
+			}
+			return nil
+		case "json":
+			encoded, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			result[prefix] = string(encoded)
+			return nil
+		}
+	}
+	result[prefix] = value
+	return nil
+}
+
+// NewFlattenFormatter turns nested objects (at path, or all the
+// fields) into a single level with keys like a.b.c (of at most
+// maxDepth parts, if set). Arrays can be kept as they are, flattened
+// by index (a.0.b), or encoded as JSON.
+func NewFlattenFormatter(rawConfig json.RawMessage) (FormatEntry, error) {
+	config := struct {
+		Path      string `json:"path"`
+		Separator string `json:"separator"`
+		MaxDepth  int    `json:"maxDepth"`
+		Arrays    string `json:"arrays"`
+	}{
+		Separator: ".",
+		Arrays:    "keep",
+	}
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, err
+	}
+
+	if config.Separator == "" {
+		return nil, errors.New("must specify separator for flatten")
+	}
+	switch config.Arrays {
+	case "keep", "index", "json":
+	default:
+		return nil, fmt.Errorf("unknown flatten arrays option %q", config.Arrays)
+	}
+
+	f := &flattener{separator: config.Separator, maxDepth: config.MaxDepth, arrays: config.Arrays}
+	path := parseFieldPath(config.Path)
+
+	return func(entry *internal.Entry) error {
+		target := entry.Fields
+		if path != nil {
+			value, ok := path.get(entry.Fields)
+			if !ok {
+				return nil
+			}
+			if target, ok = value.(map[string]interface{}); !ok {
+				return nil
+			}
+		}
+		result := make(map[string]interface{}, len(target))
+		if err := f.flatten(result, "", 0, target); err != nil {
+			return fmt.Errorf("flatten: %s", err)
+		}
+		if path == nil {
+			entry.Fields = result
+		} else {
+			path.set(entry.Fields, result)
+		}
+		return nil
+	}, nil
+}
+
+// indexedToList converts (recursively) maps whose keys are exactly
+// 0..n-1 back into lists.
+func indexedToList(value interface{}) interface{} {
+	v, ok := value.(map[string]interface{})
+	if !ok {
+		return value
+	}
+	for k, inner := range v {
+		v[k] = indexedToList(inner)
+	}
+	if len(v) == 0 {
+		return v
+	}
+	list := make([]interface{}, len(v))
+	for k, inner := range v {
+		i, err := strconv.Atoi(k)
+		if err != nil || i < 0 || i >= len(v) || strconv.Itoa(i) != k {
+			return v
+		}
+		list[i] = inner
+	}
+	return list
+}
+
+// unflatten nests keys containing the separator. Keys are handled in
+// sorted order so that conflicts (e.g. both a and a.b) are reported
+// consistently.
+func unflatten(fields map[string]interface{}, separator string) (map[string]interface{}, error) {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	result := make(map[string]interface{}, len(fields))
+	for _, k := range keys {
+		parts := strings.Split(k, separator)
+		target := result
+		for _, part := range parts[:len(parts)-1] {
+			existing, ok := target[part]
+			if !ok {
+				inner := make(map[string]interface{})
+				target[part] = inner
+				target = inner
+				continue
+			}
+			if target, ok = existing.(map[string]interface{}); !ok {
+				return nil, fmt.Errorf("%s conflicts with non-object %s", k, part)
+			}
+		}
+		last := parts[len(parts)-1]
+		if existing, ok := target[last]; ok {
+			existingMap, existingIsMap := existing.(map[string]interface{})
+			valueMap, valueIsMap := fields[k].(map[string]interface{})
+			if !existingIsMap || !valueIsMap {
+				return nil, fmt.Errorf("%s conflicts with another key", k)
+			}
+			for innerK, innerV := range valueMap {
+				existingMap[innerK] = innerV
+			}
+			continue
+		}
+		target[last] = fields[k]
+	}
+	return result, nil
+}
+
+// NewUnflattenFormatter is the reverse of flatten: keys like a.b.c
+// (at path, or all the fields) become nested objects. If arrays is
+// index, objects with keys 0..n-1 become lists again.
+func NewUnflattenFormatter(rawConfig json.RawMessage) (FormatEntry, error) {
+	config := struct {
+		errorPolicyConfig
+		Path      string `json:"path"`
+		Separator string `json:"separator"`
+		Arrays    string `json:"arrays"`
+	}{
+		Separator: ".",
+		Arrays:    "keep",
+	}
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, err
+	}
+
+	if config.Separator == "" {
+		return nil, errors.New("must specify separator for unflatten")
+	}
+	switch config.Arrays {
+	case "keep", "index":
+	default:
+		return nil, fmt.Errorf("unknown unflatten arrays option %q", config.Arrays)
+	}
+	onError, err := newErrorPolicy("unflatten", config.errorPolicyConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	path := parseFieldPath(config.Path)
+
+	return func(entry *internal.Entry) error {
+		target := entry.Fields
+		if path != nil {
+			value, ok := path.get(entry.Fields)
+			if !ok {
+				return nil
+			}
+			if target, ok = value.(map[string]interface{}); !ok {
+				return nil
+			}
+		}
+		result, err := unflatten(target, config.Separator)
+		if err != nil {
+			return onError.handle(entry, err)
+		}
+		if config.Arrays == "index" {
+			for k, v := range result {
+				result[k] = indexedToList(v)
+			}
+		}
+		if path == nil {
+			entry.Fields = result
+		} else {
+			path.set(entry.Fields, result)
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,236 @@
+package formatters
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wryun/journalship/internal"
+)
+
+func readTrimmed(fileName string) (string, error) {
+	contents, err := ioutil.ReadFile(fileName)
+	return strings.TrimSpace(string(contents)), err
+}
+
+func lookupFQDN(hostname string) string {
+	addrs, err := net.LookupHost(hostname)
+	if err != nil {
+		return hostname
+	}
+	for _, addr := range addrs {
+		names, err := net.LookupAddr(addr)
+		if err == nil && len(names) > 0 {
+			return strings.TrimSuffix(names[0], ".")
+		}
+	}
+	return hostname
+}
+
+// parseOSRelease handles the shell-ish KEY="value" format of
+// os-release(5), lowercasing the keys.
+func parseOSRelease(contents []byte, keys []string) map[string]interface{} {
+	wanted := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		wanted[key] = true
+	}
+	result := make(map[string]interface{})
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 || (len(wanted) != 0 && !wanted[kv[0]]) {
+			continue
+		}
+		value := kv[1]
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = strings.Trim(value, `"'`)
+		}
+		result[strings.ToLower(kv[0])] = value
+	}
+	return result
+}
+
+// instanceMetadata fetches from an EC2 IMDS compatible endpoint
+// (optionally using an IMDSv2 style session token).
+type instanceMetadata struct {
+	URL      string            `json:"url"`
+	TokenURL string            `json:"tokenURL"`
+	Fields   map[string]string `json:"fields"`
+	Refresh  int               `json:"refresh"`
+	Timeout  int               `json:"timeout"`
+
+	client *http.Client
+}
+
+func (im *instanceMetadata) fetch() (map[string]interface{}, error) {
+	token := ""
+	if im.TokenURL != "" {
+		req, err := http.NewRequest("PUT", im.TokenURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+		body, err := im.do(req)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get metadata token: %s", err)
+		}
+		token = body
+	}
+
+	result := make(map[string]interface{}, len(im.Fields))
+	for field, path := range im.Fields {
+		req, err := http.NewRequest("GET", strings.TrimSuffix(im.URL, "/")+"/"+strings.TrimPrefix(path, "/"), nil)
+		if err != nil {
+			return nil, err
+		}
+		if token != "" {
+			req.Header.Set("X-aws-ec2-metadata-token", token)
+		}
+		if result[field], err = im.do(req); err != nil {
+			return nil, fmt.Errorf("unable to get metadata %s: %s", path, err)
+		}
+	}
+	return result, nil
+}
+
+func (im *instanceMetadata) do(req *http.Request) (string, error) {
+	resp, err := im.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned %s", req.URL, resp.Status)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// NewHostInfoFormatter adds information about the machine we're
+// running on. Everything is gathered at startup, apart from the
+// (optional) cloud metadata which is refreshed periodically.
+func NewHostInfoFormatter(rawConfig json.RawMessage) (FormatEntry, error) {
+	config := struct {
+		OutputPath      string            `json:"outputPath"`
+		Include         []string          `json:"include"`
+		MachineIDFile   string            `json:"machineIDFile"`
+		OSReleaseFile   string            `json:"osReleaseFile"`
+		OSReleaseFields []string          `json:"osReleaseFields"`
+		KernelFile      string            `json:"kernelFile"`
+		Metadata        *instanceMetadata `json:"metadata"`
+	}{
+		OutputPath:      "host",
+		Include:         []string{"hostname", "fqdn", "machineID", "os", "kernel"},
+		MachineIDFile:   "/etc/machine-id",
+		OSReleaseFile:   "/etc/os-release",
+		OSReleaseFields: []string{"ID", "VERSION_ID", "PRETTY_NAME"},
+		KernelFile:      "/proc/sys/kernel/osrelease",
+	}
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, err
+	}
+
+	info := make(map[string]interface{})
+	for _, include := range config.Include {
+		switch include {
+		case "hostname", "fqdn":
+			hostname, err := os.Hostname()
+			if err != nil {
+				return nil, err
+			}
+			if include == "fqdn" {
+				info["fqdn"] = lookupFQDN(hostname)
+			} else {
+				info["hostname"] = hostname
+			}
+		case "machineID":
+			machineID, err := readTrimmed(config.MachineIDFile)
+			if err != nil {
+				return nil, err
+			}
+			info["machineID"] = machineID
+		case "os":
+			contents, err := ioutil.ReadFile(config.OSReleaseFile)
+			if err != nil {
+				return nil, err
+			}
+			info["os"] = parseOSRelease(contents, config.OSReleaseFields)
+		case "kernel":
+			kernel, err := readTrimmed(config.KernelFile)
+			if err != nil {
+				return nil, err
+			}
+			info["kernel"] = kernel
+		default:
+			return nil, fmt.Errorf("unknown hostinfo %q", include)
+		}
+	}
+
+	var mutex sync.RWMutex
+	if config.Metadata != nil {
+		metadata := config.Metadata
+		if metadata.URL == "" || len(metadata.Fields) == 0 {
+			return nil, fmt.Errorf("must specify url and fields for hostinfo metadata")
+		}
+		if metadata.Timeout <= 0 {
+			metadata.Timeout = 2
+		}
+		metadata.client = &http.Client{Timeout: time.Duration(metadata.Timeout) * time.Second}
+
+		// A failure here shouldn't stop us shipping logs (and it
+		// might work next time).
+		if cloud, err := metadata.fetch(); err != nil {
+			log.Printf("hostinfo: %s", err)
+		} else {
+			info["cloud"] = cloud
+		}
+		if metadata.Refresh > 0 {
+			go func() {
+				for range time.Tick(time.Duration(metadata.Refresh) * time.Second) {
+					cloud, err := metadata.fetch()
+					if err != nil {
+						log.Printf("hostinfo: %s", err)
+						continue
+					}
+					mutex.Lock()
+					info["cloud"] = cloud
+					mutex.Unlock()
+				}
+			}()
+		}
+	}
+
+	outputPath := parseFieldPath(config.OutputPath)
+
+	return func(entry *internal.Entry) error {
+		mutex.RLock()
+		defer mutex.RUnlock()
+		if outputPath == nil {
+			for k, v := range info {
+				entry.Fields[k] = deepCopy(v)
+			}
+		} else {
+			outputPath.set(entry.Fields, deepCopy(info))
+		}
+		return nil
+	}, nil
+}
@@ -3,23 +3,238 @@ package formatters
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sync"
+	"time"
 
 	jsone "github.com/taskcluster/json-e"
+	"github.com/taskcluster/json-e/interpreter"
 	"github.com/wryun/journalship/internal"
 )
 
+var jsoneOperators = map[string]bool{
+	"$eval": true, "$flatten": true, "$flattenDeep": true, "$fromNow": true,
+	"$if": true, "$json": true, "$let": true, "$map": true, "$match": true,
+	"$merge": true, "$mergeDeep": true, "$reverse": true, "$sort": true,
+	// (parts of other operators)
+	"$else": true, "$then": true,
+}
+
+// checkJsoneOperators catches typos in operator names at startup
+// (json-e itself only complains when it gets to them).
+func checkJsoneOperators(template interface{}) error {
+	switch v := template.(type) {
+	case map[string]interface{}:
+		for k, inner := range v {
+			if len(k) > 1 && k[0] == '$' && k[1] != '$' && !jsoneOperators[k] {
+				return fmt.Errorf("unknown JSON-e operator %s", k)
+			}
+			if err := checkJsoneOperators(inner); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, inner := range v {
+			if err := checkJsoneOperators(inner); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// jsonCompatible converts the integer types other formatters might
+// produce to float64, since json-e only accepts JSON types.
+func jsonCompatible(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for k, inner := range v {
+			result[k] = jsonCompatible(inner)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, inner := range v {
+			result[i] = jsonCompatible(inner)
+		}
+		return result
+	}
+	return value
+}
+
+var jsoneRegexps sync.Map
+
+func jsoneRegexp(pattern string) (*regexp.Regexp, error) {
+	if cached, ok := jsoneRegexps.Load(pattern); ok {
+		return cached.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	jsoneRegexps.Store(pattern, re)
+	return re, nil
+}
+
+// jsoneFunctions are extra helpers available to templates.
+var jsoneFunctions = map[string]interface{}{
+	"regexMatch": interpreter.WrapFunction(func(pattern string, s string) (bool, error) {
+		re, err := jsoneRegexp(pattern)
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(s), nil
+	}),
+	"regexFind": interpreter.WrapFunction(func(pattern string, s string) (interface{}, error) {
+		re, err := jsoneRegexp(pattern)
+		if err != nil {
+			return nil, err
+		}
+		match := re.FindStringSubmatch(s)
+		if match == nil {
+			return nil, nil
+		}
+		result := make([]interface{}, len(match))
+		for i, m := range match {
+			result[i] = m
+		}
+		return result, nil
+	}),
+	"hash": interpreter.WrapFunction(func(s string) string {
+		return hashString(s)
+	}),
+	"parseTime": interpreter.WrapFunction(func(value interface{}, format string) (string, error) {
+		tf, err := parseTimeFormat(format)
+		if err != nil {
+			return "", err
+		}
+		t, err := tf.parse(value, time.UTC)
+		if err != nil {
+			return "", err
+		}
+		return t.UTC().Format(time.RFC3339Nano), nil
+	}),
+}
+
+// jsoneSampleFields are what a typical entry from the journal looks
+// like, for trying out templates at startup.
+var jsoneSampleFields = map[string]interface{}{
+	"MESSAGE":                    "Started Session 1 of user root.",
+	"PRIORITY":                   "6",
+	"SYSLOG_FACILITY":            "3",
+	"SYSLOG_IDENTIFIER":          "systemd",
+	"_TRANSPORT":                 "journal",
+	"_PID":                       "1",
+	"_UID":                       "0",
+	"_GID":                       "0",
+	"_COMM":                      "systemd",
+	"_EXE":                       "/usr/lib/systemd/systemd",
+	"_CMDLINE":                   "/sbin/init",
+	"_SYSTEMD_UNIT":              "init.scope",
+	"_HOSTNAME":                  "localhost",
+	"_BOOT_ID":                   "6f3c1a9e2b8d4e7f9a0b1c2d3e4f5a6b",
+	"_MACHINE_ID":                "0123456789abcdef0123456789abcdef",
+	"_SOURCE_REALTIME_TIMESTAMP": "1700000000000000",
+}
+
+// NewJsoneFormatter replaces each entry with the result of rendering
+// a JSON-e template. The context has:
+//
+//	fields   the entry's fields
+//	journal  cursor and bootID (both empty unless the reader is
+//	         configured with entryMetadata)
+//	host     hostname
+//	env      the environment variables listed in env
+//
+// along with the functions in jsoneFunctions. The template is tried
+// out at startup so that mistakes are found early. Failing on the
+// given sampleFields stops us starting; failing on the built-in
+// sample (a typical journal entry, which won't have anything added
+// by earlier formatters) is only a warning.
 func NewJsoneFormatter(rawConfig json.RawMessage) (FormatEntry, error) {
 	var config struct {
 		Template interface{} `json:"template"`
+		// Env lists the environment variables templates can see.
+		Env []string `json:"env"`
+		// SampleFields are rendered at startup to check the template
+		// works.
+		SampleFields map[string]interface{} `json:"sampleFields"`
 	}
 	if err := json.Unmarshal(rawConfig, &config); err != nil {
 		return nil, err
 	}
+	sampleFields, builtinSample := config.SampleFields, config.SampleFields == nil
+	if builtinSample {
+		sampleFields = jsoneSampleFields
+	}
+
+	if err := checkJsoneOperators(config.Template); err != nil {
+		return nil, err
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	env := make(map[string]interface{}, len(config.Env))
+	for _, name := range config.Env {
+		if value, ok := os.LookupEnv(name); ok {
+			env[name] = value
+		}
+	}
+
+	// Everything but fields and journal is the same for every entry.
+	// (json-e adds 'now' itself)
+	baseContext := map[string]interface{}{
+		"host": map[string]interface{}{"hostname": hostname},
+		"env":  env,
+	}
+	for k, v := range jsoneFunctions {
+		baseContext[k] = v
+	}
+
+	render := func(entry *internal.Entry) (interface{}, error) {
+		context := make(map[string]interface{}, len(baseContext)+2)
+		for k, v := range baseContext {
+			context[k] = v
+		}
+		context["fields"] = jsonCompatible(entry.Fields)
+		context["journal"] = map[string]interface{}{
+			"cursor": entry.Cursor,
+			"bootID": entry.BootID,
+		}
+		return jsone.Render(config.Template, context)
+	}
+
+	// json-e has no separate compile step, so the best we can do is try
+	// it out.
+	sample := &internal.Entry{
+		Fields: deepCopy(sampleFields).(map[string]interface{}),
+		Cursor: "s=0;i=1;b=" + toString(jsoneSampleFields["_BOOT_ID"]),
+		BootID: toString(jsoneSampleFields["_BOOT_ID"]),
+	}
+	result, err := render(sample)
+	if err == nil {
+		if _, ok := result.(map[string]interface{}); !ok {
+			err = errors.New("returned non-dictionary")
+		}
+	}
+	if err != nil && builtinSample {
+		log.Printf("JSON-e template failed on a sample journal entry (set sampleFields to check it properly): %s", err)
+	} else if err != nil {
+		return nil, fmt.Errorf("JSON-e template failed on sampleFields: %s", err)
+	}
 
 	return func(entry *internal.Entry) error {
-		fields, err := jsone.Render(config.Template, map[string]interface{}{
-			"fields": entry.Fields,
-		})
+		fields, err := render(entry)
 		if err != nil {
 			return err
 		}
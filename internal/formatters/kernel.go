@@ -0,0 +1,183 @@
+package formatters
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/wryun/journalship/internal"
+)
+
+// kernelParser recognises one kind of kernel message. Named groups
+// become fields (the ones listed in ints are converted).
+type kernelParser struct {
+	event   string
+	pattern *regexp.Regexp
+	ints    map[string]bool
+}
+
+func newKernelParser(event, pattern string, ints ...string) *kernelParser {
+	parser := &kernelParser{event: event, pattern: regexp.MustCompile(pattern), ints: map[string]bool{}}
+	for _, name := range ints {
+		parser.ints[name] = true
+	}
+	return parser
+}
+
+func (p *kernelParser) parse(message string) map[string]interface{} {
+	match := p.pattern.FindStringSubmatch(message)
+	if match == nil {
+		return nil
+	}
+	result := map[string]interface{}{"event": p.event}
+	for i, name := range p.pattern.SubexpNames() {
+		if name == "" || match[i] == "" {
+			continue
+		}
+		if p.ints[name] {
+			if n, err := strconv.ParseInt(match[i], 10, 64); err == nil {
+				result[name] = n
+				continue
+			}
+		}
+		result[name] = match[i]
+	}
+	return result
+}
+
+var kernelParsers = []*kernelParser{
+	// Out of memory: Killed process 1234 (java) total-vm:8000kB, anon-rss:4000kB, file-rss:0kB, shmem-rss:0kB, UID:1000 pgtables:100kB oom_score_adj:0
+	newKernelParser("oom_kill",
+		`(?:(?P<scope>Memory cgroup) )?[Oo]ut of memory: Kill(?:ed)? process (?P<pid>\d+) \((?P<process>[^)]*)\)`+
+			`(?:.*?total-vm:(?P<totalVMKB>\d+)kB)?(?:.*?anon-rss:(?P<anonRSSKB>\d+)kB)?`+
+			`(?:.*?file-rss:(?P<fileRSSKB>\d+)kB)?(?:.*?shmem-rss:(?P<shmemRSSKB>\d+)kB)?`+
+			`(?:.*?UID:(?P<uid>\d+))?(?:.*?oom_score_adj:(?P<oomScoreAdj>-?\d+))?`,
+		"pid", "totalVMKB", "anonRSSKB", "fileRSSKB", "shmemRSSKB", "uid", "oomScoreAdj"),
+	// oom-kill:constraint=CONSTRAINT_MEMCG,nodemask=(null),...,oom_memcg=/kubepods/...,task_memcg=/kubepods/...,task=java,pid=1234,uid=1000
+	newKernelParser("oom_kill",
+		`^oom-kill:constraint=(?P<constraint>[^,]*)(?:.*?,oom_memcg=(?P<memcg>[^,]*))?(?:.*?,task_memcg=(?P<taskMemcg>[^,]*))?`+
+			`.*?,task=(?P<process>[^,]*),pid=(?P<pid>\d+)(?:,uid=(?P<uid>\d+))?`,
+		"pid", "uid"),
+	// java invoked oom-killer: gfp_mask=0x100cca(GFP_HIGHUSER_MOVABLE), order=0, oom_score_adj=0
+	newKernelParser("oom_invoked",
+		`^(?P<process>.+?) invoked oom-killer: gfp_mask=(?P<gfpMask>\S+?),? order=(?P<order>\d+),? oom_score_adj=(?P<oomScoreAdj>-?\d+)`,
+		"order", "oomScoreAdj"),
+	// java[1234]: segfault at 10 ip 00007f0000001234 sp 00007ffc00001234 error 4 in libc.so.6[7f0000000000+1bc000]
+	newKernelParser("segfault",
+		`^(?P<process>.+?)\[(?P<pid>\d+)\]: segfault at (?P<address>[0-9a-f]+) ip (?P<ip>[0-9a-f]+) sp (?P<sp>[0-9a-f]+) error (?P<error>\d+)(?: in (?P<binary>[^\[\s]+))?`,
+		"pid", "error"),
+	// traps: java[1234] general protection fault ip:7f0000001234 sp:7ffc00001234 error:0 in libc.so.6[7f0000000000+1bc000]
+	newKernelParser("general_protection",
+		`^traps: (?P<process>.+?)\[(?P<pid>\d+)\] general protection(?: fault)? ip:(?P<ip>[0-9a-f]+) sp:(?P<sp>[0-9a-f]+) error:(?P<error>\d+)(?: in (?P<binary>[^\[\s]+))?`,
+		"pid", "error"),
+	// blk_update_request: I/O error, dev sda, sector 12345 op 0x0:(READ) flags 0x0 phys_seg 1 prio class 0
+	newKernelParser("io_error",
+		`I/O error, dev (?P<device>[^,\s]+), sector (?P<sector>\d+)(?: op 0x[0-9a-f]+:\((?P<op>\w+)\))?`,
+		"sector"),
+	// Buffer I/O error on dev sda1, logical block 123, async page read
+	newKernelParser("io_error",
+		`^Buffer I/O error on dev(?:ice)? (?P<device>[^,\s]+), logical block (?P<block>\d+)`,
+		"block"),
+	// EXT4-fs error (device sda1): ext4_find_entry:1455: inode #2: comm ls: reading directory lblock 0
+	newKernelParser("fs_error",
+		`^(?P<filesystem>[A-Za-z0-9]+)-fs (?:error|critical|warning) \(device (?P<device>[^)]+)\): (?P<detail>.*)`),
+	// XFS (sda1): metadata I/O error in "xfs_trans_read_buf_map" at daddr 0x1 len 8 error 5
+	newKernelParser("fs_error",
+		`^(?P<filesystem>XFS) \((?P<device>[^)]+)\): (?P<detail>.*(?:error|corrupt|Corruption).*)`),
+	// e1000e: eth0 NIC Link is Up 1000 Mbps Full Duplex, Flow Control: Rx/Tx
+	// r8169 0000:02:00.0 eth0: Link is Up - 1Gbps/Full - flow control rx/tx
+	newKernelParser("link_up",
+		`(?i)(?:^|\s)(?P<device>[a-z0-9_.@-]+):? (?:NIC )?Link (?:is )?Up\b(?:[ ,-]+(?P<speed>\d+ ?[MG]bps))?(?:[ ,/]+(?P<duplex>Full|Half)\b)?`),
+	newKernelParser("link_down",
+		`(?i)(?:^|\s)(?P<device>[a-z0-9_.@-]+):? (?:NIC )?Link (?:is )?Down\b`),
+	// INFO: task java:1234 blocked for more than 120 seconds.
+	newKernelParser("hung_task",
+		`^INFO: task (?P<process>.+):(?P<pid>\d+) blocked for more than (?P<seconds>\d+) seconds`,
+		"pid", "seconds"),
+	// watchdog: BUG: soft lockup - CPU#3 stuck for 23s! [java:1234]
+	newKernelParser("soft_lockup",
+		`BUG: soft lockup - CPU#(?P<cpu>\d+) stuck for (?P<seconds>\d+)s! \[(?P<process>.+):(?P<pid>\d+)\]`,
+		"cpu", "seconds", "pid"),
+	newKernelParser("panic",
+		`^Kernel panic - not syncing: (?P<detail>.*)`),
+	newKernelParser("bug",
+		`^BUG: (?P<detail>.*)`),
+}
+
+// NewKernelFormatter classifies kernel messages (OOM kills, segfaults,
+// I/O and filesystem errors, link changes, etc) and pulls out their
+// details, setting (by default) kernel.event. Extra patterns (regular
+// expressions with named groups, by event name) can be given, which
+// are tried first.
+func NewKernelFormatter(rawConfig json.RawMessage) (FormatEntry, error) {
+	config := struct {
+		InputPath  string            `json:"inputPath"`
+		OutputPath string            `json:"outputPath"`
+		Transport  string            `json:"transport"`
+		Events     []string          `json:"events"`
+		Patterns   map[string]string `json:"patterns"`
+	}{
+		InputPath:  "MESSAGE",
+		OutputPath: "kernel",
+		Transport:  "kernel",
+	}
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, err
+	}
+
+	if config.InputPath == "" || config.OutputPath == "" {
+		return nil, errors.New("must specify inputPath and outputPath for kernel")
+	}
+
+	var parsers []*kernelParser
+	events := make([]string, 0, len(config.Patterns))
+	for event := range config.Patterns {
+		events = append(events, event)
+	}
+	sort.Strings(events)
+	for _, event := range events {
+		re, err := regexp.Compile(config.Patterns[event])
+		if err != nil {
+			return nil, fmt.Errorf("kernel pattern for %s: %s", event, err)
+		}
+		parsers = append(parsers, &kernelParser{event: event, pattern: re, ints: map[string]bool{}})
+	}
+	enabled := make(map[string]bool, len(config.Events))
+	for _, event := range config.Events {
+		enabled[event] = true
+	}
+	for _, parser := range kernelParsers {
+		if len(enabled) == 0 || enabled[parser.event] {
+			parsers = append(parsers, parser)
+		}
+	}
+
+	inputPath := parseFieldPath(config.InputPath)
+	outputPath := parseFieldPath(config.OutputPath)
+	counts := counters("kernel")
+
+	return func(entry *internal.Entry) error {
+		if config.Transport != "" && entry.Fields["_TRANSPORT"] != config.Transport {
+			return nil
+		}
+		value, ok := inputPath.get(entry.Fields)
+		if !ok {
+			return nil
+		}
+		message, ok := value.(string)
+		if !ok {
+			return nil
+		}
+		for _, parser := range parsers {
+			if result := parser.parse(message); result != nil {
+				counts.Add(parser.event, 1)
+				outputPath.merge(entry.Fields, result)
+				return nil
+			}
+		}
+		return nil
+	}, nil
+}
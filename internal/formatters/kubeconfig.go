@@ -0,0 +1,251 @@
+package formatters
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// kubeWatchTimeout is how long we ask the server to keep a watch
+// open. Requests get a little longer than this before we give up on
+// them (e.g. if the connection has silently gone away).
+const kubeWatchTimeout = 5 * time.Minute
+
+// kubeClient is just enough of a kubernetes API client to list and
+// watch pods (so we don't need to pull in client-go).
+type kubeClient struct {
+	server string
+	token  string
+	client *http.Client
+}
+
+func (kc *kubeClient) get(path string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", strings.TrimSuffix(kc.server, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if kc.token != "" {
+		req.Header.Set("Authorization", "Bearer "+kc.token)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := kc.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("kubernetes API returned %s for %s", resp.Status, path)
+	}
+	return resp, nil
+}
+
+type kubeClientConfig struct {
+	APIServer          string `json:"apiServer"`
+	TokenFile          string `json:"tokenFile"`
+	CAFile             string `json:"caFile"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify"`
+	Kubeconfig         string `json:"kubeconfig"`
+}
+
+// newKubeClient uses (in order of preference) an explicitly
+// configured API server, a kubeconfig file, or the in-cluster
+// service account.
+func newKubeClient(config kubeClientConfig) (*kubeClient, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+	kc := &kubeClient{server: config.APIServer}
+
+	switch {
+	case config.APIServer != "":
+		if config.TokenFile != "" {
+			token, err := readTrimmed(config.TokenFile)
+			if err != nil {
+				return nil, err
+			}
+			kc.token = token
+		}
+		if config.CAFile != "" {
+			if err := addCAFile(tlsConfig, config.CAFile); err != nil {
+				return nil, err
+			}
+		}
+	case config.Kubeconfig != "":
+		if err := loadKubeconfig(config.Kubeconfig, kc, tlsConfig); err != nil {
+			return nil, fmt.Errorf("kubeconfig %s: %s", config.Kubeconfig, err)
+		}
+	default:
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, errors.New("not running in a cluster (and no apiServer or kubeconfig specified)")
+		}
+		kc.server = "https://" + net.JoinHostPort(host, port)
+		token, err := readTrimmed(filepath.Join(serviceAccountDir, "token"))
+		if err != nil {
+			return nil, err
+		}
+		kc.token = token
+		if err := addCAFile(tlsConfig, filepath.Join(serviceAccountDir, "ca.crt")); err != nil {
+			return nil, err
+		}
+	}
+
+	kc.client = &http.Client{
+		Timeout: kubeWatchTimeout + time.Minute,
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConfig,
+			DialContext: (&net.Dialer{
+				Timeout:   10 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+		},
+	}
+	return kc, nil
+}
+
+func addCAFile(tlsConfig *tls.Config, caFile string) error {
+	ca, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return err
+	}
+	return addCA(tlsConfig, ca)
+}
+
+func addCA(tlsConfig *tls.Config, ca []byte) error {
+	if tlsConfig.RootCAs == nil {
+		tlsConfig.RootCAs = x509.NewCertPool()
+	}
+	if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+		return errors.New("no certificates found in CA")
+	}
+	return nil
+}
+
+// fileOrData returns the base64 decoded data if set, otherwise the
+// contents of the file (relative to the kubeconfig).
+func fileOrData(baseDir, fileName, data string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if fileName == "" {
+		return nil, nil
+	}
+	if !filepath.IsAbs(fileName) {
+		fileName = filepath.Join(baseDir, fileName)
+	}
+	return ioutil.ReadFile(fileName)
+}
+
+func loadKubeconfig(fileName string, kc *kubeClient, tlsConfig *tls.Config) error {
+	contents, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	var kubeconfig struct {
+		CurrentContext string `json:"current-context"`
+		Contexts       []struct {
+			Name    string `json:"name"`
+			Context struct {
+				Cluster string `json:"cluster"`
+				User    string `json:"user"`
+			} `json:"context"`
+		} `json:"contexts"`
+		Clusters []struct {
+			Name    string `json:"name"`
+			Cluster struct {
+				Server                   string `json:"server"`
+				CertificateAuthority     string `json:"certificate-authority"`
+				CertificateAuthorityData string `json:"certificate-authority-data"`
+				InsecureSkipTLSVerify    bool   `json:"insecure-skip-tls-verify"`
+			} `json:"cluster"`
+		} `json:"clusters"`
+		Users []struct {
+			Name string `json:"name"`
+			User struct {
+				Token                 string `json:"token"`
+				TokenFile             string `json:"tokenFile"`
+				ClientCertificate     string `json:"client-certificate"`
+				ClientCertificateData string `json:"client-certificate-data"`
+				ClientKey             string `json:"client-key"`
+				ClientKeyData         string `json:"client-key-data"`
+			} `json:"user"`
+		} `json:"users"`
+	}
+	if err := yaml.Unmarshal(contents, &kubeconfig); err != nil {
+		return err
+	}
+	baseDir := filepath.Dir(fileName)
+
+	var clusterName, userName string
+	for _, context := range kubeconfig.Contexts {
+		if context.Name == kubeconfig.CurrentContext {
+			clusterName, userName = context.Context.Cluster, context.Context.User
+		}
+	}
+	if clusterName == "" {
+		return fmt.Errorf("current context %q not found", kubeconfig.CurrentContext)
+	}
+
+	foundCluster := false
+	for _, cluster := range kubeconfig.Clusters {
+		if cluster.Name != clusterName {
+			continue
+		}
+		foundCluster = true
+		kc.server = cluster.Cluster.Server
+		tlsConfig.InsecureSkipVerify = tlsConfig.InsecureSkipVerify || cluster.Cluster.InsecureSkipTLSVerify
+		ca, err := fileOrData(baseDir, cluster.Cluster.CertificateAuthority, cluster.Cluster.CertificateAuthorityData)
+		if err != nil {
+			return err
+		}
+		if ca != nil {
+			if err := addCA(tlsConfig, ca); err != nil {
+				return err
+			}
+		}
+	}
+	if !foundCluster {
+		return fmt.Errorf("cluster %q not found", clusterName)
+	}
+
+	for _, user := range kubeconfig.Users {
+		if user.Name != userName {
+			continue
+		}
+		kc.token = user.User.Token
+		if user.User.TokenFile != "" {
+			if kc.token, err = readTrimmed(user.User.TokenFile); err != nil {
+				return err
+			}
+		}
+		cert, err := fileOrData(baseDir, user.User.ClientCertificate, user.User.ClientCertificateData)
+		if err != nil {
+			return err
+		}
+		key, err := fileOrData(baseDir, user.User.ClientKey, user.User.ClientKeyData)
+		if err != nil {
+			return err
+		}
+		if cert != nil && key != nil {
+			keyPair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return err
+			}
+			tlsConfig.Certificates = []tls.Certificate{keyPair}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,376 @@
+package formatters
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wryun/journalship/internal"
+)
+
+type kubePod struct {
+	Metadata struct {
+		Name            string            `json:"name"`
+		Namespace       string            `json:"namespace"`
+		UID             string            `json:"uid"`
+		ResourceVersion string            `json:"resourceVersion"`
+		Labels          map[string]string `json:"labels"`
+		OwnerReferences []struct {
+			Kind string `json:"kind"`
+			Name string `json:"name"`
+		} `json:"ownerReferences"`
+	} `json:"metadata"`
+	Spec struct {
+		NodeName string `json:"nodeName"`
+	} `json:"spec"`
+	Status struct {
+		ContainerStatuses          []kubeContainerStatus `json:"containerStatuses"`
+		InitContainerStatuses      []kubeContainerStatus `json:"initContainerStatuses"`
+		EphemeralContainerStatuses []kubeContainerStatus `json:"ephemeralContainerStatuses"`
+	} `json:"status"`
+}
+
+type kubeContainerStatus struct {
+	Name        string `json:"name"`
+	ContainerID string `json:"containerID"`
+}
+
+type kubeContainer struct {
+	podUID string
+	info   map[string]interface{}
+	// expires is set once the container (or its pod) has gone, since
+	// its last few log lines are likely still on their way.
+	expires time.Time
+}
+
+// podIndex maps container ids to metadata about their pod.
+type podIndex struct {
+	mutex      sync.RWMutex
+	containers map[string]*kubeContainer
+	pods       map[string][]string
+	labelAllow []string
+	labelDeny  []string
+	retention  time.Duration
+}
+
+func (pi *podIndex) describe(pod *kubePod, containerName string) map[string]interface{} {
+	labels := make(map[string]interface{})
+	for k, v := range pod.Metadata.Labels {
+		if (len(pi.labelAllow) == 0 || matchesAny(pi.labelAllow, k)) && !matchesAny(pi.labelDeny, k) {
+			labels[k] = v
+		}
+	}
+	owners := make([]interface{}, 0, len(pod.Metadata.OwnerReferences))
+	for _, owner := range pod.Metadata.OwnerReferences {
+		owners = append(owners, map[string]interface{}{"kind": owner.Kind, "name": owner.Name})
+	}
+	return map[string]interface{}{
+		"namespace": pod.Metadata.Namespace,
+		"pod":       pod.Metadata.Name,
+		"podUID":    pod.Metadata.UID,
+		"container": containerName,
+		"node":      pod.Spec.NodeName,
+		"labels":    labels,
+		"owners":    owners,
+	}
+}
+
+// update must be called with the lock held.
+func (pi *podIndex) update(pod *kubePod) {
+	var ids []string
+	for _, statuses := range [][]kubeContainerStatus{
+		pod.Status.ContainerStatuses,
+		pod.Status.InitContainerStatuses,
+		pod.Status.EphemeralContainerStatuses,
+	} {
+		for _, status := range statuses {
+			// e.g. containerd://<id>
+			id := status.ContainerID
+			if i := strings.Index(id, "://"); i != -1 {
+				id = id[i+3:]
+			}
+			if id == "" {
+				continue
+			}
+			pi.containers[id] = &kubeContainer{podUID: pod.Metadata.UID, info: pi.describe(pod, status.Name)}
+			ids = append(ids, id)
+		}
+	}
+	// (e.g. the previous container of a restarted one)
+	current := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		current[id] = true
+	}
+	for _, id := range pi.pods[pod.Metadata.UID] {
+		if !current[id] {
+			pi.retire(id, pod.Metadata.UID)
+		}
+	}
+	pi.pods[pod.Metadata.UID] = ids
+}
+
+// remove retires the containers of a pod which has gone. It must be
+// called with the lock held.
+func (pi *podIndex) remove(uid string) {
+	for _, id := range pi.pods[uid] {
+		pi.retire(id, uid)
+	}
+	delete(pi.pods, uid)
+}
+
+// retire must be called with the lock held.
+func (pi *podIndex) retire(id, uid string) {
+	if container, ok := pi.containers[id]; ok && container.podUID == uid && container.expires.IsZero() {
+		container.expires = time.Now().Add(pi.retention)
+	}
+}
+
+// expire forgets retired containers once their time is up. It must
+// be called with the lock held.
+func (pi *podIndex) expire() {
+	now := time.Now()
+	for id, container := range pi.containers {
+		if !container.expires.IsZero() && now.After(container.expires) {
+			delete(pi.containers, id)
+		}
+	}
+}
+
+func (pi *podIndex) lookup(id string) map[string]interface{} {
+	pi.mutex.RLock()
+	defer pi.mutex.RUnlock()
+	if container, ok := pi.containers[id]; ok {
+		return container.info
+	}
+	// docker (by default) logs abbreviated ids
+	if len(id) >= 12 && len(id) < 64 {
+		for fullID, container := range pi.containers {
+			if strings.HasPrefix(fullID, id) {
+				return container.info
+			}
+		}
+	}
+	return nil
+}
+
+// podWatcher keeps a podIndex up to date with the pods on our node.
+type podWatcher struct {
+	client   *kubeClient
+	nodeName string
+	index    *podIndex
+}
+
+func (pw *podWatcher) run() {
+	backoff := time.Second
+	for {
+		started := time.Now()
+		err := pw.listAndWatch()
+		log.Printf("kubernetes: pod watch ended: %s", err)
+		if time.Since(started) > time.Minute {
+			backoff = time.Second
+		}
+		time.Sleep(backoff)
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+func (pw *podWatcher) listAndWatch() error {
+	selector := url.QueryEscape("spec.nodeName=" + pw.nodeName)
+	resp, err := pw.client.get("/api/v1/pods?fieldSelector=" + selector)
+	if err != nil {
+		return err
+	}
+	var list struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Items []kubePod `json:"items"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	// Pods which went while we weren't watching are retired rather
+	// than forgotten, like any other deleted pod.
+	listed := make(map[string]bool, len(list.Items))
+	for i := range list.Items {
+		listed[list.Items[i].Metadata.UID] = true
+	}
+	pw.index.mutex.Lock()
+	for uid := range pw.index.pods {
+		if !listed[uid] {
+			pw.index.remove(uid)
+		}
+	}
+	for i := range list.Items {
+		pw.index.update(&list.Items[i])
+	}
+	pw.index.expire()
+	pw.index.mutex.Unlock()
+
+	// The server ends each watch after kubeWatchTimeout, at which
+	// point we carry on from where it got to.
+	resourceVersion := list.Metadata.ResourceVersion
+	for {
+		if resourceVersion, err = pw.watch(selector, resourceVersion); err != nil {
+			return err
+		}
+	}
+}
+
+// watch applies changes to the index until the watch ends, returning
+// the resourceVersion it got up to.
+func (pw *podWatcher) watch(selector, resourceVersion string) (string, error) {
+	resp, err := pw.client.get(fmt.Sprintf("/api/v1/pods?watch=1&fieldSelector=%s&resourceVersion=%s&timeoutSeconds=%d",
+		selector, url.QueryEscape(resourceVersion), int(kubeWatchTimeout/time.Second)))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event struct {
+			Type   string          `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}
+		if err := decoder.Decode(&event); err == io.EOF {
+			return resourceVersion, nil
+		} else if err != nil {
+			return "", err
+		}
+		switch event.Type {
+		case "ADDED", "MODIFIED", "DELETED":
+			var pod kubePod
+			if err := json.Unmarshal(event.Object, &pod); err != nil {
+				return "", err
+			}
+			resourceVersion = pod.Metadata.ResourceVersion
+			pw.index.mutex.Lock()
+			if event.Type == "DELETED" {
+				pw.index.remove(pod.Metadata.UID)
+			} else {
+				pw.index.update(&pod)
+			}
+			pw.index.expire()
+			pw.index.mutex.Unlock()
+		case "ERROR":
+			// (usually our resourceVersion is too old, so relist)
+			return "", fmt.Errorf("watch error: %s", event.Object)
+		}
+	}
+}
+
+// NewKubernetesFormatter adds pod metadata to entries from
+// containers on this node. If the API server is unavailable,
+// entries are passed through without it (and we keep retrying).
+func NewKubernetesFormatter(rawConfig json.RawMessage) (FormatEntry, error) {
+	config := struct {
+		kubeClientConfig
+		NodeName         string   `json:"nodeName"`
+		IDFields         []string `json:"idFields"`
+		UnitPattern      string   `json:"unitPattern"`
+		OutputPath       string   `json:"outputPath"`
+		LabelAllow       []string `json:"labelAllow"`
+		LabelDeny        []string `json:"labelDeny"`
+		DeletedRetention int      `json:"deletedRetention"`
+	}{
+		NodeName:         os.Getenv("NODE_NAME"),
+		IDFields:         []string{"CONTAINER_ID_FULL", "CONTAINER_ID"},
+		UnitPattern:      `^(?:cri-containerd|crio|docker)-([0-9a-f]{64})\.scope$`,
+		OutputPath:       "kubernetes",
+		DeletedRetention: 300,
+	}
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, err
+	}
+
+	if config.NodeName == "" {
+		var err error
+		if config.NodeName, err = os.Hostname(); err != nil {
+			return nil, err
+		}
+	}
+	if config.OutputPath == "" {
+		return nil, errors.New("must specify outputPath for kubernetes")
+	}
+	var unitPattern *regexp.Regexp
+	if config.UnitPattern != "" {
+		var err error
+		if unitPattern, err = regexp.Compile(config.UnitPattern); err != nil {
+			return nil, err
+		}
+	}
+	client, err := newKubeClient(config.kubeClientConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	index := &podIndex{
+		containers: make(map[string]*kubeContainer),
+		pods:       make(map[string][]string),
+		labelAllow: config.LabelAllow,
+		labelDeny:  config.LabelDeny,
+		retention:  time.Duration(config.DeletedRetention) * time.Second,
+	}
+	watcher := &podWatcher{
+		client:   client,
+		nodeName: config.NodeName,
+		index:    index,
+	}
+	go watcher.run()
+
+	idPaths := make([]fieldPath, 0, len(config.IDFields))
+	for _, field := range config.IDFields {
+		idPaths = append(idPaths, parseFieldPath(field))
+	}
+	outputPath := parseFieldPath(config.OutputPath)
+	unitPath := fieldPath{"_SYSTEMD_UNIT"}
+	counts := counters("kubernetes")
+
+	containerID := func(fields map[string]interface{}) string {
+		for _, path := range idPaths {
+			if id, ok := path.get(fields); ok {
+				if s, ok := id.(string); ok && s != "" {
+					return s
+				}
+			}
+		}
+		if unitPattern != nil {
+			if unit, ok := unitPath.get(fields); ok {
+				if s, ok := unit.(string); ok {
+					if match := unitPattern.FindStringSubmatch(s); len(match) > 1 {
+						return match[1]
+					}
+				}
+			}
+		}
+		return ""
+	}
+
+	return func(entry *internal.Entry) error {
+		id := containerID(entry.Fields)
+		if id == "" {
+			return nil
+		}
+		info := index.lookup(id)
+		if info == nil {
+			counts.Add("misses", 1)
+			return nil
+		}
+		outputPath.set(entry.Fields, deepCopy(info))
+		return nil
+	}, nil
+}
@@ -0,0 +1,160 @@
+package formatters
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/wryun/journalship/internal"
+)
+
+// kvParser handles logfmt (level=info msg="hi there") and similar
+// key/value formats with configurable separators.
+type kvParser struct {
+	pairSeparator string
+	kvSeparator   string
+	quoteChars    string
+}
+
+func (p *kvParser) parse(input string) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	i := 0
+	for i < len(input) {
+		if strings.HasPrefix(input[i:], p.pairSeparator) {
+			i += len(p.pairSeparator)
+			continue
+		}
+
+		keyEnd := i
+		for keyEnd < len(input) &&
+			!strings.HasPrefix(input[keyEnd:], p.kvSeparator) &&
+			!strings.HasPrefix(input[keyEnd:], p.pairSeparator) {
+			keyEnd++
+		}
+		key := input[i:keyEnd]
+		i = keyEnd
+
+		if !strings.HasPrefix(input[i:], p.kvSeparator) {
+			// bare key (logfmt treats these as flags)
+			result[key] = true
+			continue
+		}
+		i += len(p.kvSeparator)
+
+		if key == "" {
+			return nil, fmt.Errorf("empty key at offset %d", keyEnd)
+		}
+
+		var value string
+		if i < len(input) && strings.IndexByte(p.quoteChars, input[i]) != -1 {
+			var err error
+			value, i, err = parseQuoted(input, i)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			valueEnd := strings.Index(input[i:], p.pairSeparator)
+			if valueEnd == -1 {
+				valueEnd = len(input) - i
+			}
+			value = input[i : i+valueEnd]
+			i += valueEnd
+		}
+		result[key] = value
+	}
+	return result, nil
+}
+
+// parseQuoted reads a quoted value starting at input[start] (which
+// is the quote character), returning the unescaped value and the
+// offset just after the closing quote.
+func parseQuoted(input string, start int) (string, int, error) {
+	quote := input[start]
+	var value strings.Builder
+	for i := start + 1; i < len(input); i++ {
+		c := input[i]
+		switch {
+		case c == quote:
+			return value.String(), i + 1, nil
+		case c == '\\' && i+1 < len(input):
+			i++
+			switch input[i] {
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			case 'r':
+				value.WriteByte('\r')
+			default:
+				value.WriteByte(input[i])
+			}
+		default:
+			value.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated quote at offset %d", start)
+}
+
+func NewLogfmtFormatter(rawConfig json.RawMessage) (FormatEntry, error) {
+	config := struct {
+		InputPath     string `json:"inputPath"`
+		OutputPath    string `json:"outputPath"`
+		Prefix        string `json:"prefix"`
+		KeepOriginal  bool   `json:"keepOriginal"`
+		PairSeparator string `json:"pairSeparator"`
+		KVSeparator   string `json:"kvSeparator"`
+		QuoteChars    string `json:"quoteChars"`
+	}{
+		PairSeparator: " ",
+		KVSeparator:   "=",
+		QuoteChars:    `"`,
+	}
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, err
+	}
+
+	if config.InputPath == "" {
+		return nil, errors.New("must specify field to parse")
+	}
+	if config.PairSeparator == "" || config.KVSeparator == "" {
+		return nil, errors.New("separators must not be empty")
+	}
+
+	inputPath := parseFieldPath(config.InputPath)
+	outputPath := parseFieldPath(config.OutputPath)
+	parser := &kvParser{
+		pairSeparator: config.PairSeparator,
+		kvSeparator:   config.KVSeparator,
+		quoteChars:    config.QuoteChars,
+	}
+
+	return func(entry *internal.Entry) error {
+		something, ok := inputPath.get(entry.Fields)
+		if !ok {
+			return nil
+		}
+		input, ok := something.(string)
+		if !ok {
+			return nil
+		}
+
+		values, err := parser.parse(input)
+		if err != nil {
+			return fmt.Errorf("unable to parse %s as key/value pairs: %s", inputPath, err)
+		}
+		if config.Prefix != "" {
+			prefixed := make(map[string]interface{}, len(values))
+			for k, v := range values {
+				prefixed[config.Prefix+k] = v
+			}
+			values = prefixed
+		}
+
+		if !config.KeepOriginal {
+			inputPath.delete(entry.Fields)
+		}
+		outputPath.merge(entry.Fields, values)
+		return nil
+	}, nil
+}
@@ -0,0 +1,273 @@
+package formatters
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/wryun/journalship/internal"
+)
+
+// journalMapping says where a well-known journal field goes (and
+// whether it should be an integer there).
+type journalMapping struct {
+	path    fieldPath
+	integer bool
+}
+
+// logModel describes how to translate journal entries into a
+// standard log schema. Anything it doesn't know about ends up under
+// the namespace.
+type logModel struct {
+	timestamp fieldPath
+	body      fieldPath
+	namespace string
+	fields    map[string]journalMapping
+	severity  func(result map[string]interface{}, code int)
+	facility  func(result map[string]interface{}, code int)
+	// container and kubernetes map the output of those formatters
+	container       map[string]fieldPath
+	containerLabel  func(string) fieldPath
+	kubernetes      map[string]fieldPath
+	kubernetesLabel func(string) fieldPath
+	constant        map[string]interface{}
+}
+
+func otelAttribute(name string) fieldPath { return fieldPath{"attributes", name} }
+func otelResource(name string) fieldPath  { return fieldPath{"resource", name} }
+
+// otelModel follows the OpenTelemetry log data model, with semantic
+// convention names for resource attributes and attributes.
+var otelModel = &logModel{
+	timestamp: fieldPath{"timestamp"},
+	body:      fieldPath{"body"},
+	namespace: "attributes.journald",
+	fields: map[string]journalMapping{
+		"_HOSTNAME":         {otelResource("host.name"), false},
+		"_MACHINE_ID":       {otelResource("host.id"), false},
+		"_SYSTEMD_UNIT":     {otelResource("service.name"), false},
+		"CONTAINER_ID_FULL": {otelResource("container.id"), false},
+		"CONTAINER_NAME":    {otelResource("container.name"), false},
+		"IMAGE_NAME":        {otelResource("container.image.name"), false},
+		"_PID":              {otelAttribute("process.pid"), true},
+		"_COMM":             {otelAttribute("process.executable.name"), false},
+		"_EXE":              {otelAttribute("process.executable.path"), false},
+		"_CMDLINE":          {otelAttribute("process.command_line"), false},
+		"_UID":              {otelAttribute("process.user.id"), true},
+		"TID":               {otelAttribute("thread.id"), true},
+		"CODE_FILE":         {otelAttribute("code.filepath"), false},
+		"CODE_LINE":         {otelAttribute("code.lineno"), true},
+		"CODE_FUNC":         {otelAttribute("code.function"), false},
+	},
+	severity: func(result map[string]interface{}, code int) {
+		result["severity_text"] = syslogSeverityNames[code]
+		result["severity_number"] = otelSeverityNumbers[code]
+	},
+	facility: func(result map[string]interface{}, code int) {
+		otelAttribute("syslog.facility").set(result, syslogFacilityNames[code])
+	},
+	container: map[string]fieldPath{
+		"id":    otelResource("container.id"),
+		"name":  otelResource("container.name"),
+		"image": otelResource("container.image.name"),
+	},
+	containerLabel: func(label string) fieldPath {
+		return otelResource("container.label." + label)
+	},
+	kubernetes: map[string]fieldPath{
+		"namespace": otelResource("k8s.namespace.name"),
+		"pod":       otelResource("k8s.pod.name"),
+		"podUID":    otelResource("k8s.pod.uid"),
+		"container": otelResource("k8s.container.name"),
+		"node":      otelResource("k8s.node.name"),
+	},
+	kubernetesLabel: func(label string) fieldPath {
+		return otelResource("k8s.pod.label." + label)
+	},
+}
+
+// ecsModel follows the Elastic Common Schema (and what filebeat does
+// for kubernetes metadata, which ECS doesn't cover).
+var ecsModel = &logModel{
+	timestamp: fieldPath{"@timestamp"},
+	body:      fieldPath{"message"},
+	namespace: "journald",
+	fields: map[string]journalMapping{
+		"_HOSTNAME":         {parseFieldPath("host.hostname"), false},
+		"_MACHINE_ID":       {parseFieldPath("host.id"), false},
+		"_SYSTEMD_UNIT":     {parseFieldPath("service.name"), false},
+		"CONTAINER_ID_FULL": {parseFieldPath("container.id"), false},
+		"CONTAINER_NAME":    {parseFieldPath("container.name"), false},
+		"IMAGE_NAME":        {parseFieldPath("container.image.name"), false},
+		"_PID":              {parseFieldPath("process.pid"), true},
+		"_COMM":             {parseFieldPath("process.name"), false},
+		"_EXE":              {parseFieldPath("process.executable"), false},
+		"_CMDLINE":          {parseFieldPath("process.command_line"), false},
+		"TID":               {parseFieldPath("process.thread.id"), true},
+		"_UID":              {parseFieldPath("user.id"), false},
+		"SYSLOG_IDENTIFIER": {parseFieldPath("log.syslog.appname"), false},
+		"SYSLOG_PID":        {parseFieldPath("log.syslog.procid"), false},
+		"CODE_FILE":         {parseFieldPath("log.origin.file.name"), false},
+		"CODE_LINE":         {parseFieldPath("log.origin.file.line"), true},
+		"CODE_FUNC":         {parseFieldPath("log.origin.function"), false},
+	},
+	severity: func(result map[string]interface{}, code int) {
+		parseFieldPath("log.level").set(result, syslogSeverityNames[code])
+		parseFieldPath("log.syslog.severity.code").set(result, code)
+		parseFieldPath("log.syslog.severity.name").set(result, syslogSeverityNames[code])
+	},
+	facility: func(result map[string]interface{}, code int) {
+		parseFieldPath("log.syslog.facility.code").set(result, code)
+		parseFieldPath("log.syslog.facility.name").set(result, syslogFacilityNames[code])
+	},
+	container: map[string]fieldPath{
+		"id":     parseFieldPath("container.id"),
+		"name":   parseFieldPath("container.name"),
+		"image":  parseFieldPath("container.image.name"),
+		"labels": parseFieldPath("container.labels"),
+	},
+	kubernetes: map[string]fieldPath{
+		"namespace": parseFieldPath("kubernetes.namespace"),
+		"pod":       parseFieldPath("kubernetes.pod.name"),
+		"podUID":    parseFieldPath("kubernetes.pod.uid"),
+		"container": parseFieldPath("kubernetes.container.name"),
+		"node":      parseFieldPath("kubernetes.node.name"),
+		"labels":    parseFieldPath("kubernetes.labels"),
+	},
+	constant: map[string]interface{}{
+		"ecs": map[string]interface{}{"version": "8.11.0"},
+	},
+}
+
+// mapInfo copies the parts of (e.g.) the container formatter's output
+// that the model knows about.
+func mapInfo(result map[string]interface{}, info map[string]interface{}, mapping map[string]fieldPath, label func(string) fieldPath) {
+	for k, path := range mapping {
+		if value, ok := info[k]; ok {
+			path.set(result, deepCopy(value))
+		}
+	}
+	if labels, ok := info["labels"].(map[string]interface{}); ok && label != nil {
+		for k, v := range labels {
+			label(k).set(result, v)
+		}
+	}
+}
+
+var sourceTimestampFormat = timeFormat{epochUnit: time.Microsecond}
+
+func newLogModelFormatter(model *logModel, rawConfig json.RawMessage) (FormatEntry, error) {
+	config := struct {
+		Namespace      *string `json:"namespace"`
+		TimePath       string  `json:"timePath"`
+		ContainerPath  string  `json:"containerPath"`
+		KubernetesPath string  `json:"kubernetesPath"`
+	}{
+		TimePath:       "TIME",
+		ContainerPath:  "container",
+		KubernetesPath: "kubernetes",
+	}
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, err
+	}
+
+	// (an empty namespace drops unknown fields)
+	namespace := model.namespace
+	if config.Namespace != nil {
+		namespace = *config.Namespace
+	}
+	namespacePath := parseFieldPath(namespace)
+	timePath := parseFieldPath(config.TimePath)
+	containerPath := parseFieldPath(config.ContainerPath)
+	kubernetesPath := parseFieldPath(config.KubernetesPath)
+
+	// The top level field of each of these is consumed.
+	known := map[string]bool{"MESSAGE": true, "PRIORITY": true, "SYSLOG_FACILITY": true, "_SOURCE_REALTIME_TIMESTAMP": true}
+	for _, path := range []fieldPath{timePath, containerPath, kubernetesPath} {
+		if path != nil {
+			known[path[0]] = true
+		}
+	}
+	for name := range model.fields {
+		known[name] = true
+	}
+
+	return func(entry *internal.Entry) error {
+		fields := entry.Fields
+		result := make(map[string]interface{})
+		for k, v := range model.constant {
+			result[k] = deepCopy(v)
+		}
+
+		if value, ok := timePath.get(fields); ok && timePath != nil {
+			model.timestamp.set(result, value)
+		} else if value, ok := fields["_SOURCE_REALTIME_TIMESTAMP"]; ok {
+			if t, err := sourceTimestampFormat.parse(value, time.UTC); err == nil {
+				model.timestamp.set(result, t.UTC().Format(time.RFC3339Nano))
+			}
+		}
+		if value, ok := fields["MESSAGE"]; ok {
+			model.body.set(result, value)
+		}
+		if value, ok := fields["PRIORITY"]; ok {
+			if code, ok := syslogCode(value, syslogSeverityNames); ok {
+				model.severity(result, code)
+			}
+		}
+		if value, ok := fields["SYSLOG_FACILITY"]; ok {
+			if code, ok := syslogCode(value, syslogFacilityNames); ok {
+				model.facility(result, code)
+			}
+		}
+
+		for name, mapping := range model.fields {
+			value, ok := fields[name]
+			if !ok {
+				continue
+			}
+			if mapping.integer {
+				if converted, err := convertInt(value); err == nil {
+					value = converted
+				}
+			}
+			mapping.path.set(result, value)
+		}
+
+		if info, ok := containerPath.get(fields); ok && containerPath != nil {
+			if infoMap, ok := info.(map[string]interface{}); ok {
+				mapInfo(result, infoMap, model.container, model.containerLabel)
+			}
+		}
+		if info, ok := kubernetesPath.get(fields); ok && kubernetesPath != nil {
+			if infoMap, ok := info.(map[string]interface{}); ok {
+				mapInfo(result, infoMap, model.kubernetes, model.kubernetesLabel)
+			}
+		}
+
+		if namespacePath != nil {
+			unknown := make(map[string]interface{})
+			for k, v := range fields {
+				if !known[k] {
+					unknown[k] = v
+				}
+			}
+			if len(unknown) > 0 {
+				namespacePath.merge(result, unknown)
+			}
+		}
+
+		entry.Fields = result
+		return nil
+	}, nil
+}
+
+// NewOtelFormatter restructures entries to match the OpenTelemetry
+// log data model.
+func NewOtelFormatter(rawConfig json.RawMessage) (FormatEntry, error) {
+	return newLogModelFormatter(otelModel, rawConfig)
+}
+
+// NewEcsFormatter restructures entries to match the Elastic Common
+// Schema.
+func NewEcsFormatter(rawConfig json.RawMessage) (FormatEntry, error) {
+	return newLogModelFormatter(ecsModel, rawConfig)
+}
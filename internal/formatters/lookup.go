@@ -0,0 +1,322 @@
+package formatters
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/wryun/journalship/internal"
+)
+
+type lookupRow struct {
+	key     string
+	columns map[string]interface{}
+}
+
+// readLookupRows reads either CSV (with a header row) or JSON/YAML,
+// which can be a list of rows or an object of key -> columns.
+func readLookupRows(contents []byte, format, keyColumn string) ([]lookupRow, error) {
+	var rows []lookupRow
+	if format == "csv" {
+		records, err := csv.NewReader(bytes.NewReader(contents)).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		if len(records) == 0 {
+			return nil, errors.New("no header row")
+		}
+		header := records[0]
+		keyIndex := -1
+		for i, name := range header {
+			if name == keyColumn {
+				keyIndex = i
+			}
+		}
+		if keyIndex == -1 {
+			return nil, fmt.Errorf("no %s column", keyColumn)
+		}
+		for _, record := range records[1:] {
+			columns := make(map[string]interface{}, len(header)-1)
+			for i, name := range header {
+				if i != keyIndex {
+					columns[name] = record[i]
+				}
+			}
+			rows = append(rows, lookupRow{record[keyIndex], columns})
+		}
+		return rows, nil
+	}
+
+	var table interface{}
+	var err error
+	if format == "yaml" {
+		err = yaml.Unmarshal(contents, &table)
+	} else {
+		err = json.Unmarshal(contents, &table)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch v := table.(type) {
+	case []interface{}:
+		for _, something := range v {
+			row, ok := something.(map[string]interface{})
+			if !ok {
+				return nil, errors.New("rows must be objects")
+			}
+			key, ok := row[keyColumn].(string)
+			if !ok {
+				return nil, fmt.Errorf("row without a string %s", keyColumn)
+			}
+			columns := make(map[string]interface{}, len(row)-1)
+			for k, value := range row {
+				if k != keyColumn {
+					columns[k] = value
+				}
+			}
+			rows = append(rows, lookupRow{key, columns})
+		}
+	case map[string]interface{}:
+		for key, something := range v {
+			columns, ok := something.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("value for %s must be an object", key)
+			}
+			rows = append(rows, lookupRow{key, columns})
+		}
+	default:
+		return nil, errors.New("must be a list of rows or an object")
+	}
+	return rows, nil
+}
+
+type lookupFn func(string) map[string]interface{}
+
+func newExactLookup(rows []lookupRow) (lookupFn, error) {
+	table := make(map[string]map[string]interface{}, len(rows))
+	for _, row := range rows {
+		table[row.key] = row.columns
+	}
+	return func(value string) map[string]interface{} {
+		return table[value]
+	}, nil
+}
+
+// newPrefixLookup finds the longest matching prefix.
+func newPrefixLookup(rows []lookupRow) (lookupFn, error) {
+	table := make(map[string]map[string]interface{}, len(rows))
+	for _, row := range rows {
+		table[row.key] = row.columns
+	}
+	return func(value string) map[string]interface{} {
+		for i := len(value); i >= 0; i-- {
+			if columns, ok := table[value[:i]]; ok {
+				return columns
+			}
+		}
+		return nil
+	}, nil
+}
+
+// newCIDRLookup finds the most specific network containing an
+// address (plain addresses are treated as /32 or /128).
+func newCIDRLookup(rows []lookupRow) (lookupFn, error) {
+	type network struct {
+		ipNet   *net.IPNet
+		size    int
+		columns map[string]interface{}
+	}
+	networks := make([]network, 0, len(rows))
+	for _, row := range rows {
+		key := row.key
+		if !strings.Contains(key, "/") {
+			if ip := net.ParseIP(key); ip != nil && ip.To4() != nil {
+				key += "/32"
+			} else {
+				key += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(key)
+		if err != nil {
+			return nil, err
+		}
+		size, _ := ipNet.Mask.Size()
+		networks = append(networks, network{ipNet, size, row.columns})
+	}
+	sort.SliceStable(networks, func(i, j int) bool {
+		return networks[i].size > networks[j].size
+	})
+	return func(value string) map[string]interface{} {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil
+		}
+		for _, n := range networks {
+			if n.ipNet.Contains(ip) {
+				return n.columns
+			}
+		}
+		return nil
+	}, nil
+}
+
+// newRegexLookup uses the first matching row (in file order for
+// CSV and lists).
+func newRegexLookup(rows []lookupRow) (lookupFn, error) {
+	patterns := make([]*regexp.Regexp, len(rows))
+	for i, row := range rows {
+		var err error
+		if patterns[i], err = regexp.Compile(row.key); err != nil {
+			return nil, err
+		}
+	}
+	return func(value string) map[string]interface{} {
+		for i, pattern := range patterns {
+			if pattern.MatchString(value) {
+				return rows[i].columns
+			}
+		}
+		return nil
+	}, nil
+}
+
+var lookupMatchers = map[string]func([]lookupRow) (lookupFn, error){
+	"exact":  newExactLookup,
+	"prefix": newPrefixLookup,
+	"cidr":   newCIDRLookup,
+	"regex":  newRegexLookup,
+}
+
+// NewLookupFormatter adds the columns from a table (in a CSV, JSON
+// or YAML file) whose key matches a field. The file is checked for
+// changes every reloadInterval and swapped in once it's been loaded
+// successfully (if it's broken, we keep using the old one).
+func NewLookupFormatter(rawConfig json.RawMessage) (FormatEntry, error) {
+	config := struct {
+		File           string   `json:"file"`
+		Format         string   `json:"format"`
+		InputPath      string   `json:"inputPath"`
+		KeyColumn      string   `json:"keyColumn"`
+		Match          string   `json:"match"`
+		Columns        []string `json:"columns"`
+		OutputPath     string   `json:"outputPath"`
+		ReloadInterval int      `json:"reloadInterval"`
+	}{
+		KeyColumn:      "key",
+		Match:          "exact",
+		ReloadInterval: 10,
+	}
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, err
+	}
+
+	if config.File == "" || config.InputPath == "" {
+		return nil, errors.New("must specify file and inputPath for lookup")
+	}
+	if config.Format == "" {
+		switch strings.ToLower(filepath.Ext(config.File)) {
+		case ".csv":
+			config.Format = "csv"
+		case ".yaml", ".yml":
+			config.Format = "yaml"
+		default:
+			config.Format = "json"
+		}
+	}
+	switch config.Format {
+	case "csv", "json", "yaml":
+	default:
+		return nil, fmt.Errorf("unknown lookup format %q", config.Format)
+	}
+	newLookup, ok := lookupMatchers[config.Match]
+	if !ok {
+		return nil, fmt.Errorf("unknown lookup match %q", config.Match)
+	}
+
+	load := func() (lookupFn, error) {
+		contents, err := ioutil.ReadFile(config.File)
+		if err != nil {
+			return nil, err
+		}
+		rows, err := readLookupRows(contents, config.Format, config.KeyColumn)
+		if err != nil {
+			return nil, fmt.Errorf("lookup %s: %s", config.File, err)
+		}
+		if config.Columns != nil {
+			for i, row := range rows {
+				columns := make(map[string]interface{}, len(config.Columns))
+				for _, name := range config.Columns {
+					if value, ok := row.columns[name]; ok {
+						columns[name] = value
+					}
+				}
+				rows[i].columns = columns
+			}
+		}
+		return newLookup(rows)
+	}
+
+	initial, err := load()
+	if err != nil {
+		return nil, err
+	}
+	var current atomic.Value
+	current.Store(initial)
+	counts := counters("lookup")
+
+	if config.ReloadInterval > 0 {
+		info, err := os.Stat(config.File)
+		if err != nil {
+			return nil, err
+		}
+		go func() {
+			modTime, size := info.ModTime(), info.Size()
+			for range time.Tick(time.Duration(config.ReloadInterval) * time.Second) {
+				info, err := os.Stat(config.File)
+				if err != nil || (info.ModTime().Equal(modTime) && info.Size() == size) {
+					continue
+				}
+				modTime, size = info.ModTime(), info.Size()
+				lookup, err := load()
+				if err != nil {
+					counts.Add("reloadErrors", 1)
+					log.Printf("lookup: keeping previous %s: %s", config.File, err)
+					continue
+				}
+				counts.Add("reloads", 1)
+				current.Store(lookup)
+			}
+		}()
+	}
+
+	inputPath := parseFieldPath(config.InputPath)
+	outputPath := parseFieldPath(config.OutputPath)
+
+	return func(entry *internal.Entry) error {
+		value, ok := inputPath.get(entry.Fields)
+		if !ok {
+			return nil
+		}
+		columns := current.Load().(lookupFn)(toString(value))
+		if columns == nil {
+			counts.Add("misses", 1)
+			return nil
+		}
+		counts.Add("hits", 1)
+		outputPath.merge(entry.Fields, deepCopy(columns).(map[string]interface{}))
+		return nil
+	}, nil
+}
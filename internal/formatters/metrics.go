@@ -0,0 +1,22 @@
+package formatters
+
+import (
+	"expvar"
+	"sync"
+)
+
+var countersMutex sync.Mutex
+
+// counters returns the expvar map for a formatter type, which is
+// shared if the same formatter is configured more than once. These
+// are exposed (as /debug/vars) if a metricsAddress is configured.
+func counters(formatter string) *expvar.Map {
+	countersMutex.Lock()
+	defer countersMutex.Unlock()
+
+	name := "formatters." + formatter
+	if existing, ok := expvar.Get(name).(*expvar.Map); ok {
+		return existing
+	}
+	return expvar.NewMap(name)
+}
@@ -0,0 +1,113 @@
+package formatters
+
+import (
+	"fmt"
+	"strings"
+)
+
+// fieldPath is a dotted path (e.g. 'message.request.id') into the
+// nested maps of an entry's fields.
+type fieldPath []string
+
+func parseFieldPath(path string) fieldPath {
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, ".")
+}
+
+func (p fieldPath) String() string {
+	return strings.Join(p, ".")
+}
+
+func (p fieldPath) get(fields map[string]interface{}) (interface{}, bool) {
+	var something interface{} = fields
+	for _, f := range p {
+		fieldMap, ok := something.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if something, ok = fieldMap[f]; !ok {
+			return nil, false
+		}
+	}
+	return something, true
+}
+
+// parent returns the map holding the last element of the path,
+// creating (or replacing non-map values with) intermediate maps if
+// create is set.
+func (p fieldPath) parent(fields map[string]interface{}, create bool) map[string]interface{} {
+	fieldMap := fields
+	for _, f := range p[:len(p)-1] {
+		next, ok := fieldMap[f].(map[string]interface{})
+		if !ok {
+			if !create {
+				return nil
+			}
+			next = make(map[string]interface{})
+			fieldMap[f] = next
+		}
+		fieldMap = next
+	}
+	return fieldMap
+}
+
+func (p fieldPath) set(fields map[string]interface{}, v interface{}) {
+	p.parent(fields, true)[p[len(p)-1]] = v
+}
+
+func (p fieldPath) delete(fields map[string]interface{}) {
+	if fieldMap := p.parent(fields, false); fieldMap != nil {
+		delete(fieldMap, p[len(p)-1])
+	}
+}
+
+// merge adds values to the map at the path (or the top level if the
+// path is empty), replacing whatever was there if it wasn't a map.
+func (p fieldPath) merge(fields map[string]interface{}, values map[string]interface{}) {
+	target := fields
+	if len(p) != 0 {
+		existing, ok := p.get(fields)
+		if target, ok = existing.(map[string]interface{}); !ok {
+			p.set(fields, values)
+			return
+		}
+	}
+	for k, v := range values {
+		target[k] = v
+	}
+}
+
+// joinValues builds a key (e.g. for grouping entries) out of the
+// values of several fields.
+func joinValues(fields map[string]interface{}, paths []fieldPath) string {
+	var key strings.Builder
+	for _, path := range paths {
+		if value, ok := path.get(fields); ok {
+			fmt.Fprint(&key, value)
+		}
+		key.WriteByte(0)
+	}
+	return key.String()
+}
+
+// deepCopy copies nested maps/lists so that shared values (e.g.
+// from config) can't be modified by later formatters.
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for k, inner := range v {
+			result[k] = deepCopy(inner)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, inner := range v {
+			result[i] = deepCopy(inner)
+		}
+		return result
+	}
+	return value
+}
@@ -0,0 +1,156 @@
+package formatters
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/wryun/journalship/internal"
+)
+
+type tokenBucket struct {
+	tokens     float64
+	lastRefill time.Time
+	dropped    int
+	keyValues  map[string]interface{}
+}
+
+// take refills the bucket based on how long it's been, then takes a
+// token if there's one available.
+func (b *tokenBucket) take(now time.Time, rate float64, burst float64) bool {
+	b.tokens += now.Sub(b.lastRefill).Seconds() * rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.lastRefill = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// NewRateLimitFormatter throttles each source (by default, each unit)
+// separately so one noisy service can't starve the others. Once a
+// source is over its limit, entries are kept with probability
+// sampleRate (by default, none are). Summaries of what was dropped
+// are emitted every summaryInterval (or at least, with the first
+// entry after that).
+func NewRateLimitFormatter(rawConfig json.RawMessage) (FormatEntry, error) {
+	config := struct {
+		KeyFields       []string `json:"keyFields"`
+		Rate            float64  `json:"rate"`
+		Burst           float64  `json:"burst"`
+		SampleRate      float64  `json:"sampleRate"`
+		PriorityField   string   `json:"priorityField"`
+		ExemptPriority  int      `json:"exemptPriority"`
+		SummaryInterval int      `json:"summaryInterval"`
+		MessageField    string   `json:"messageField"`
+		SummaryPath     string   `json:"summaryPath"`
+	}{
+		KeyFields:       []string{"_SYSTEMD_UNIT"},
+		Rate:            100,
+		Burst:           200,
+		PriorityField:   "PRIORITY",
+		ExemptPriority:  3,
+		SummaryInterval: 60,
+		MessageField:    "MESSAGE",
+		SummaryPath:     "ratelimit",
+	}
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, err
+	}
+
+	if config.Rate <= 0 || config.Burst < 1 {
+		return nil, errors.New("ratelimit rate must be positive and burst at least 1")
+	}
+	if config.SampleRate < 0 || config.SampleRate > 1 {
+		return nil, errors.New("ratelimit sampleRate must be between 0 and 1")
+	}
+	if config.SummaryInterval <= 0 {
+		return nil, errors.New("ratelimit summaryInterval must be positive")
+	}
+
+	keyPaths := make([]fieldPath, 0, len(config.KeyFields))
+	for _, field := range config.KeyFields {
+		keyPaths = append(keyPaths, parseFieldPath(field))
+	}
+	priorityPath := parseFieldPath(config.PriorityField)
+	messagePath := parseFieldPath(config.MessageField)
+	summaryPath := parseFieldPath(config.SummaryPath)
+	summaryInterval := time.Duration(config.SummaryInterval) * time.Second
+	counts := counters("ratelimit")
+
+	var mutex sync.Mutex
+	buckets := make(map[string]*tokenBucket)
+	lastSummary := time.Now()
+
+	summarise := func(entry *internal.Entry, now time.Time) {
+		for key, bucket := range buckets {
+			if bucket.dropped > 0 {
+				fields := make(map[string]interface{}, len(bucket.keyValues)+2)
+				for k, v := range bucket.keyValues {
+					fields[k] = v
+				}
+				messagePath.set(fields, fmt.Sprintf("rate limited: dropped %d entries", bucket.dropped))
+				summaryPath.set(fields, map[string]interface{}{
+					"dropped":  bucket.dropped,
+					"interval": now.Sub(lastSummary).Seconds(),
+				})
+				entry.Emit(fields)
+				bucket.dropped = 0
+			} else if now.Sub(bucket.lastRefill) >= summaryInterval {
+				// idle for a while (so it'd be full), no point remembering it
+				delete(buckets, key)
+			}
+		}
+		lastSummary = now
+	}
+
+	return func(entry *internal.Entry) error {
+		if config.ExemptPriority >= 0 {
+			if value, ok := priorityPath.get(entry.Fields); ok {
+				if priority, ok := syslogCode(value, syslogSeverityNames); ok && priority <= config.ExemptPriority {
+					return nil
+				}
+			}
+		}
+
+		now := time.Now()
+		key := joinValues(entry.Fields, keyPaths)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if now.Sub(lastSummary) >= summaryInterval {
+			summarise(entry, now)
+		}
+
+		bucket, ok := buckets[key]
+		if !ok {
+			keyValues := make(map[string]interface{}, len(keyPaths))
+			for _, path := range keyPaths {
+				if value, ok := path.get(entry.Fields); ok {
+					path.set(keyValues, value)
+				}
+			}
+			bucket = &tokenBucket{tokens: config.Burst, lastRefill: now, keyValues: keyValues}
+			buckets[key] = bucket
+		}
+
+		if bucket.take(now, config.Rate, config.Burst) {
+			return nil
+		}
+		if config.SampleRate > 0 && rand.Float64() < config.SampleRate {
+			counts.Add("sampled", 1)
+			return nil
+		}
+		bucket.dropped++
+		counts.Add("dropped", 1)
+		entry.Fields = nil
+		return nil
+	}, nil
+}
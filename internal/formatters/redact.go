@@ -0,0 +1,269 @@
+package formatters
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/wryun/journalship/internal"
+)
+
+// redactRule finds things to redact. If the regex has a capture
+// group, only the first group is redacted (e.g. the token, not the
+// word 'Bearer').
+type redactRule struct {
+	name     string
+	regex    *regexp.Regexp
+	validate func(string) bool
+}
+
+var builtinRedactRules = map[string]redactRule{
+	"email": {
+		regex: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`),
+	},
+	"ipv4": {
+		regex:    regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`),
+		validate: func(s string) bool { return net.ParseIP(s) != nil },
+	},
+	"ipv6": {
+		regex: regexp.MustCompile(`(?i)\b[0-9a-f]{0,4}(?::[0-9a-f]{0,4}){2,7}(?:(?:\d{1,3}\.){3}\d{1,3})?\b`),
+		validate: func(s string) bool {
+			return strings.Count(s, ":") >= 2 && net.ParseIP(s) != nil
+		},
+	},
+	"creditCard": {
+		regex:    regexp.MustCompile(`\b(?:\d[ \-]?){12,18}\d\b`),
+		validate: cardNumberValid,
+	},
+	"bearerToken": {
+		regex: regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9\-._~+/]+=*)`),
+	},
+}
+
+// cardNumberValid wants a payment card prefix (2-6, which rules out
+// things like epoch timestamps) as well as a good checksum.
+func cardNumberValid(s string) bool {
+	return s[0] >= '2' && s[0] <= '6' && luhnValid(s)
+}
+
+func luhnValid(s string) bool {
+	sum := 0
+	double := false
+	digits := 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+		digits++
+	}
+	return digits >= 13 && sum%10 == 0
+}
+
+type redactor struct {
+	rules       []redactRule
+	mode        string
+	replacement string
+	maskChar    string
+	maskKeep    int
+	hashKey     []byte
+	counts      *expvar.Map
+}
+
+func (r *redactor) redactValue(rule *redactRule, value string) string {
+	switch r.mode {
+	case "mask":
+		keep := r.maskKeep
+		n := utf8.RuneCountInString(value)
+		if keep > n/2 {
+			// never reveal most of a short value
+			keep = n / 2
+		}
+		runes := []rune(value)
+		return strings.Repeat(r.maskChar, n-keep) + string(runes[n-keep:])
+	case "hash":
+		mac := hmac.New(sha256.New, r.hashKey)
+		mac.Write([]byte(value))
+		return fmt.Sprintf("[%s:%s]", rule.name, hex.EncodeToString(mac.Sum(nil))[:16])
+	}
+	return strings.Replace(r.replacement, "{rule}", rule.name, -1)
+}
+
+func (r *redactor) redactString(s string) string {
+	for i := range r.rules {
+		rule := &r.rules[i]
+		matches := rule.regex.FindAllStringSubmatchIndex(s, -1)
+		if matches == nil {
+			continue
+		}
+
+		var result strings.Builder
+		last := 0
+		for _, match := range matches {
+			start, end := match[0], match[1]
+			if len(match) >= 4 && match[2] != -1 {
+				start, end = match[2], match[3]
+			}
+			if rule.validate != nil && !rule.validate(s[start:end]) {
+				continue
+			}
+			result.WriteString(s[last:start])
+			result.WriteString(r.redactValue(rule, s[start:end]))
+			last = end
+			r.counts.Add(rule.name, 1)
+		}
+		result.WriteString(s[last:])
+		s = result.String()
+	}
+	return s
+}
+
+func (r *redactor) redactAll(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return r.redactString(v)
+	case map[string]interface{}:
+		for k, inner := range v {
+			v[k] = r.redactAll(inner)
+		}
+	case []interface{}:
+		for i, inner := range v {
+			v[i] = r.redactAll(inner)
+		}
+	}
+	return value
+}
+
+func NewRedactFormatter(rawConfig json.RawMessage) (FormatEntry, error) {
+	config := struct {
+		Fields    []string `json:"fields"`
+		Detectors []string `json:"detectors"`
+		Patterns  []struct {
+			Name  string `json:"name"`
+			Regex string `json:"regex"`
+		} `json:"patterns"`
+		Mode        string `json:"mode"`
+		Replacement string `json:"replacement"`
+		MaskChar    string `json:"maskChar"`
+		MaskKeep    int    `json:"maskKeep"`
+		HashKey     string `json:"hashKey"`
+		HashKeyFile string `json:"hashKeyFile"`
+		// ExcludeFields are skipped when fields isn't set (by default,
+		// journal metadata which can look like card numbers)
+		ExcludeFields []string `json:"excludeFields"`
+	}{
+		Detectors: []string{"email", "ipv4", "ipv6", "creditCard", "bearerToken"},
+		ExcludeFields: []string{
+			"_BOOT_ID", "_MACHINE_ID", "_SOURCE_REALTIME_TIMESTAMP", "_SOURCE_MONOTONIC_TIMESTAMP",
+			"__CURSOR", "__REALTIME_TIMESTAMP", "__MONOTONIC_TIMESTAMP",
+		},
+		Mode: "replace",
+		// {rule} is replaced with the name of the matching rule
+		Replacement: "[REDACTED:{rule}]",
+		MaskChar:    "*",
+		MaskKeep:    4,
+	}
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, err
+	}
+
+	r := &redactor{
+		mode:        config.Mode,
+		replacement: config.Replacement,
+		maskChar:    config.MaskChar,
+		maskKeep:    config.MaskKeep,
+		counts:      counters("redact"),
+	}
+
+	for _, name := range config.Detectors {
+		rule, ok := builtinRedactRules[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown redact detector %q", name)
+		}
+		rule.name = name
+		r.rules = append(r.rules, rule)
+	}
+	for _, pattern := range config.Patterns {
+		if pattern.Name == "" {
+			return nil, errors.New("must specify name for redact pattern")
+		}
+		regex, err := regexp.Compile(pattern.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("redact pattern %s: %s", pattern.Name, err)
+		}
+		r.rules = append(r.rules, redactRule{name: pattern.Name, regex: regex})
+	}
+	if len(r.rules) == 0 {
+		return nil, errors.New("must specify at least one redact detector or pattern")
+	}
+
+	switch config.Mode {
+	case "replace":
+	case "mask":
+		if config.MaskChar == "" {
+			return nil, errors.New("must specify maskChar for mask mode")
+		}
+		if config.MaskKeep < 0 {
+			return nil, errors.New("redact maskKeep must not be negative")
+		}
+	case "hash":
+		r.hashKey = []byte(config.HashKey)
+		if config.HashKeyFile != "" {
+			var err error
+			if r.hashKey, err = ioutil.ReadFile(config.HashKeyFile); err != nil {
+				return nil, err
+			}
+			r.hashKey = bytes.TrimRight(r.hashKey, "\r\n")
+		}
+		if len(r.hashKey) == 0 {
+			return nil, errors.New("must specify hashKey or hashKeyFile for hash mode")
+		}
+	default:
+		return nil, fmt.Errorf("unknown redact mode %q", config.Mode)
+	}
+
+	paths := make([]fieldPath, 0, len(config.Fields))
+	for _, field := range config.Fields {
+		paths = append(paths, parseFieldPath(field))
+	}
+	exclude := make(map[string]bool, len(config.ExcludeFields))
+	for _, field := range config.ExcludeFields {
+		exclude[field] = true
+	}
+
+	return func(entry *internal.Entry) error {
+		if len(paths) == 0 {
+			for k, value := range entry.Fields {
+				if !exclude[k] {
+					entry.Fields[k] = r.redactAll(value)
+				}
+			}
+			return nil
+		}
+		for _, path := range paths {
+			if value, ok := path.get(entry.Fields); ok {
+				path.set(entry.Fields, r.redactAll(value))
+			}
+		}
+		return nil
+	}, nil
+}
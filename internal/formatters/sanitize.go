@@ -0,0 +1,183 @@
+package formatters
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/wryun/journalship/internal"
+)
+
+// CSI sequences (colours, cursor movement), OSC sequences (titles,
+// hyperlinks) and the remaining two character escapes.
+var ansiRegexp = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+type sanitizeOptions struct {
+	ANSI        string `json:"ansi"`
+	Control     string `json:"control"`
+	InvalidUTF8 string `json:"invalidUTF8"`
+	LineEndings string `json:"lineEndings"`
+}
+
+// inherit fills in anything unset from defaults.
+func (o sanitizeOptions) inherit(defaults sanitizeOptions) sanitizeOptions {
+	if o.ANSI == "" {
+		o.ANSI = defaults.ANSI
+	}
+	if o.Control == "" {
+		o.Control = defaults.Control
+	}
+	if o.InvalidUTF8 == "" {
+		o.InvalidUTF8 = defaults.InvalidUTF8
+	}
+	if o.LineEndings == "" {
+		o.LineEndings = defaults.LineEndings
+	}
+	return o
+}
+
+func (o sanitizeOptions) validate() error {
+	check := func(name, value string, allowed ...string) error {
+		for _, a := range allowed {
+			if value == a {
+				return nil
+			}
+		}
+		return fmt.Errorf("sanitize %s must be one of %s (not %q)", name, strings.Join(allowed, ", "), value)
+	}
+	if err := check("ansi", o.ANSI, "strip", "escape", "keep"); err != nil {
+		return err
+	}
+	if err := check("control", o.Control, "strip", "escape", "keep"); err != nil {
+		return err
+	}
+	if err := check("invalidUTF8", o.InvalidUTF8, "replace", "strip", "escape"); err != nil {
+		return err
+	}
+	return check("lineEndings", o.LineEndings, "lf", "keep")
+}
+
+func escapeRune(r rune) string {
+	if r < 0x80 {
+		return fmt.Sprintf(`\x%02x`, r)
+	}
+	return fmt.Sprintf(`\u%04x`, r)
+}
+
+func (o sanitizeOptions) sanitize(s string) string {
+	if !utf8.ValidString(s) {
+		var b strings.Builder
+		for i := 0; i < len(s); {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if r == utf8.RuneError && size == 1 {
+				switch o.InvalidUTF8 {
+				case "replace":
+					b.WriteRune(utf8.RuneError)
+				case "escape":
+					fmt.Fprintf(&b, `\x%02x`, s[i])
+				}
+			} else {
+				b.WriteString(s[i : i+size])
+			}
+			i += size
+		}
+		s = b.String()
+	}
+
+	if o.LineEndings == "lf" && strings.IndexByte(s, '\r') != -1 {
+		s = strings.Replace(s, "\r\n", "\n", -1)
+		s = strings.Replace(s, "\r", "\n", -1)
+	}
+
+	switch o.ANSI {
+	case "strip":
+		s = ansiRegexp.ReplaceAllString(s, "")
+	case "escape":
+		s = ansiRegexp.ReplaceAllStringFunc(s, func(seq string) string {
+			return strings.Replace(seq, "\x1b", escapeRune('\x1b'), -1)
+		})
+	}
+
+	if o.Control != "keep" {
+		var b strings.Builder
+		last := 0
+		if o.ANSI == "keep" {
+			// (kept sequences start with ESC, so mustn't be touched)
+			for _, match := range ansiRegexp.FindAllStringIndex(s, -1) {
+				o.writeControl(&b, s[last:match[0]])
+				b.WriteString(s[match[0]:match[1]])
+				last = match[1]
+			}
+		}
+		o.writeControl(&b, s[last:])
+		s = b.String()
+	}
+	return s
+}
+
+// writeControl writes s to b with control characters (other than
+// line endings and tabs) stripped or escaped.
+func (o sanitizeOptions) writeControl(b *strings.Builder, s string) {
+	for _, r := range s {
+		switch {
+		case !unicode.IsControl(r) || r == '\n' || r == '\t' || r == '\r':
+			b.WriteRune(r)
+		case o.Control == "escape":
+			b.WriteString(escapeRune(r))
+		}
+	}
+}
+
+func NewSanitizeFormatter(rawConfig json.RawMessage) (FormatEntry, error) {
+	config := struct {
+		sanitizeOptions
+		Fields map[string]sanitizeOptions `json:"fields"`
+	}{
+		sanitizeOptions: sanitizeOptions{
+			ANSI:        "strip",
+			Control:     "strip",
+			InvalidUTF8: "replace",
+			LineEndings: "lf",
+		},
+	}
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, err
+	}
+
+	if err := config.sanitizeOptions.validate(); err != nil {
+		return nil, err
+	}
+
+	type fieldSanitizer struct {
+		path    fieldPath
+		options sanitizeOptions
+	}
+	fieldSanitizers := make([]fieldSanitizer, 0, len(config.Fields))
+	for field, options := range config.Fields {
+		options = options.inherit(config.sanitizeOptions)
+		if err := options.validate(); err != nil {
+			return nil, fmt.Errorf("field %s: %s", field, err)
+		}
+		fieldSanitizers = append(fieldSanitizers, fieldSanitizer{parseFieldPath(field), options})
+	}
+
+	return func(entry *internal.Entry) error {
+		if len(fieldSanitizers) == 0 {
+			walkStrings(entry.Fields, nil, func(_ fieldPath, s string) string {
+				return config.sanitizeOptions.sanitize(s)
+			})
+			return nil
+		}
+		for _, fs := range fieldSanitizers {
+			if value, ok := fs.path.get(entry.Fields); ok {
+				fs.path.set(entry.Fields, walkStrings(value, fs.path, func(_ fieldPath, s string) string {
+					return fs.options.sanitize(s)
+				}))
+			}
+		}
+		return nil
+	}, nil
+}
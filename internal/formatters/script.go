@@ -0,0 +1,289 @@
+package formatters
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/wryun/journalship/internal"
+	starlarkjson "go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+)
+
+func toStarlark(value interface{}) (starlark.Value, error) {
+	switch v := value.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(v), nil
+	case string:
+		return starlark.String(v), nil
+	case float64:
+		return starlark.Float(v), nil
+	case int64:
+		return starlark.MakeInt64(v), nil
+	case int:
+		return starlark.MakeInt(v), nil
+	case []interface{}:
+		elems := make([]starlark.Value, len(v))
+		for i, inner := range v {
+			var err error
+			if elems[i], err = toStarlark(inner); err != nil {
+				return nil, err
+			}
+		}
+		return starlark.NewList(elems), nil
+	case map[string]interface{}:
+		dict := starlark.NewDict(len(v))
+		for k, inner := range v {
+			innerValue, err := toStarlark(inner)
+			if err != nil {
+				return nil, err
+			}
+			if err := dict.SetKey(starlark.String(k), innerValue); err != nil {
+				return nil, err
+			}
+		}
+		return dict, nil
+	}
+	return nil, fmt.Errorf("unable to pass %T to script", value)
+}
+
+// errScriptResultTooLarge stops conversion once a result goes over
+// maxResultBytes.
+var errScriptResultTooLarge = errors.New("script result larger than maxResultBytes")
+
+// scriptValueBytes is roughly what a non-string value costs.
+const scriptValueBytes = 8
+
+// fromStarlark converts a script's result, taking (roughly) its size
+// from budget as it goes.
+func fromStarlark(value starlark.Value, budget *int) (interface{}, error) {
+	*budget -= scriptValueBytes
+	switch v := value.(type) {
+	case starlark.String:
+		*budget -= len(v)
+	case starlark.Bytes:
+		*budget -= len(v)
+	}
+	if *budget < 0 {
+		return nil, errScriptResultTooLarge
+	}
+
+	switch v := value.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.String:
+		return string(v), nil
+	case starlark.Bytes:
+		return string(v), nil
+	case starlark.Float:
+		return float64(v), nil
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return i, nil
+		}
+		return v.String(), nil
+	case *starlark.List, starlark.Tuple:
+		list := v.(starlark.Indexable)
+		// (checked up front so we don't allocate something huge)
+		if *budget -= list.Len() * scriptValueBytes; *budget < 0 {
+			return nil, errScriptResultTooLarge
+		}
+		result := make([]interface{}, list.Len())
+		for i := range result {
+			var err error
+			if result[i], err = fromStarlark(list.Index(i), budget); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	case *starlark.Dict:
+		return starlarkFields(v, budget)
+	}
+	return nil, fmt.Errorf("script returned unsupported type %s", value.Type())
+}
+
+func starlarkFields(dict *starlark.Dict, budget *int) (map[string]interface{}, error) {
+	if *budget -= dict.Len() * scriptValueBytes; *budget < 0 {
+		return nil, errScriptResultTooLarge
+	}
+	fields := make(map[string]interface{}, dict.Len())
+	for _, item := range dict.Items() {
+		k, ok := item[0].(starlark.String)
+		if !ok {
+			return nil, fmt.Errorf("script returned non-string key %s", item[0])
+		}
+		if *budget -= len(k); *budget < 0 {
+			return nil, errScriptResultTooLarge
+		}
+		var err error
+		if fields[string(k)], err = fromStarlark(item[1], budget); err != nil {
+			return nil, err
+		}
+	}
+	return fields, nil
+}
+
+// NewScriptFormatter calls a Starlark function with each entry's
+// fields. It can return a dict (the new fields), None (to drop the
+// entry) or a list of dicts (to turn one entry into several).
+//
+// The script's globals are frozen after it's loaded, so they're
+// shared; each transformer goroutine ends up with its own thread
+// from the pool. Every call is limited by maxSteps and timeout, and
+// results over maxResultBytes are treated as errors. Starlark has no
+// way to limit the memory a script uses while it runs (and something
+// like 'x' * 1000000000 is a single step), so scripts must be trusted.
+func NewScriptFormatter(rawConfig json.RawMessage) (FormatEntry, error) {
+	config := struct {
+		errorPolicyConfig
+		Script         string `json:"script"`
+		File           string `json:"file"`
+		Function       string `json:"function"`
+		MaxSteps       uint64 `json:"maxSteps"`
+		Timeout        int    `json:"timeout"`
+		MaxResultBytes int    `json:"maxResultBytes"`
+	}{
+		Function:       "transform",
+		MaxSteps:       100000,
+		Timeout:        100,
+		MaxResultBytes: 1 << 20,
+	}
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, err
+	}
+
+	var src interface{}
+	fileName := "script"
+	switch {
+	case config.Script != "" && config.File != "":
+		return nil, errors.New("must specify only one of script or file")
+	case config.Script != "":
+		src = config.Script
+	case config.File != "":
+		contents, err := ioutil.ReadFile(config.File)
+		if err != nil {
+			return nil, err
+		}
+		src = contents
+		fileName = config.File
+	default:
+		return nil, errors.New("must specify script or file")
+	}
+	if config.MaxSteps == 0 {
+		return nil, errors.New("script maxSteps must be positive")
+	}
+	if config.MaxResultBytes <= 0 {
+		return nil, errors.New("script maxResultBytes must be positive")
+	}
+	onError, err := newErrorPolicy("script", config.errorPolicyConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	printFn := func(_ *starlark.Thread, msg string) {
+		log.Printf("script: %s", msg)
+	}
+	predeclared := starlark.StringDict{"json": starlarkjson.Module}
+	globals, err := starlark.ExecFile(&starlark.Thread{Name: "load", Print: printFn}, fileName, src, predeclared)
+	if err != nil {
+		return nil, err
+	}
+	globals.Freeze()
+	fn, ok := globals[config.Function].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("script does not define function %s", config.Function)
+	}
+
+	timeout := time.Duration(config.Timeout) * time.Millisecond
+	threads := sync.Pool{New: func() interface{} {
+		return &starlark.Thread{Name: "script", Print: printFn}
+	}}
+	counts := counters("script")
+
+	call := func(fields map[string]interface{}) (starlark.Value, error) {
+		arg, err := toStarlark(fields)
+		if err != nil {
+			return nil, err
+		}
+		thread := threads.Get().(*starlark.Thread)
+		thread.Uncancel()
+		thread.SetMaxExecutionSteps(thread.ExecutionSteps() + config.MaxSteps)
+		var timer *time.Timer
+		if timeout > 0 {
+			timer = time.AfterFunc(timeout, func() {
+				thread.Cancel("timed out")
+			})
+		}
+		result, err := starlark.Call(thread, fn, starlark.Tuple{arg}, nil)
+		// If the timer's already gone off, it might cancel the thread
+		// at any point, so we can't reuse it.
+		if timer == nil || timer.Stop() {
+			threads.Put(thread)
+		}
+		return result, err
+	}
+
+	return func(entry *internal.Entry) error {
+		result, err := call(entry.Fields)
+		if err != nil {
+			counts.Add("errors", 1)
+			return onError.handle(entry, err)
+		}
+
+		// (shared by everything this call returns)
+		budget := config.MaxResultBytes
+		switch v := result.(type) {
+		case starlark.NoneType:
+			counts.Add("dropped", 1)
+			entry.Fields = nil
+		case *starlark.Dict:
+			fields, err := starlarkFields(v, &budget)
+			if err != nil {
+				counts.Add("errors", 1)
+				return onError.handle(entry, err)
+			}
+			entry.Fields = fields
+		case *starlark.List, starlark.Tuple:
+			// The last one replaces this entry; the others are emitted
+			// (which are shipped first, so order is preserved).
+			all := v.(starlark.Indexable)
+			if budget -= all.Len() * scriptValueBytes; budget < 0 {
+				counts.Add("errors", 1)
+				return onError.handle(entry, errScriptResultTooLarge)
+			}
+			results := make([]map[string]interface{}, all.Len())
+			for i := range results {
+				dict, ok := all.Index(i).(*starlark.Dict)
+				if !ok {
+					counts.Add("errors", 1)
+					return onError.handle(entry, fmt.Errorf("script returned list containing %s", all.Index(i).Type()))
+				}
+				if results[i], err = starlarkFields(dict, &budget); err != nil {
+					counts.Add("errors", 1)
+					return onError.handle(entry, err)
+				}
+			}
+			if len(results) == 0 {
+				counts.Add("dropped", 1)
+				entry.Fields = nil
+				return nil
+			}
+			for _, fields := range results[:len(results)-1] {
+				entry.Emit(fields)
+			}
+			entry.Fields = results[len(results)-1]
+		default:
+			counts.Add("errors", 1)
+			return onError.handle(entry, fmt.Errorf("script returned %s (expected dict, list or None)", result.Type()))
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,65 @@
+package formatters
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/wryun/journalship/internal"
+)
+
+func TestScriptConvertsResults(t *testing.T) {
+	format, err := NewScriptFormatter(json.RawMessage(`{
+		"script": "def transform(fields):\n    return {'bytes': b'ab', 'tuple': (1, 'x'), 'list': [None, True]}"
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := &internal.Entry{Fields: map[string]interface{}{"MESSAGE": "hello"}}
+	if err := format(entry); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"bytes": "ab",
+		"tuple": []interface{}{int64(1), "x"},
+		"list":  []interface{}{nil, true},
+	}
+	if !reflect.DeepEqual(entry.Fields, expected) {
+		t.Errorf("got %v, expected %v", entry.Fields, expected)
+	}
+}
+
+func TestScriptRejectsOtherSequences(t *testing.T) {
+	format, err := NewScriptFormatter(json.RawMessage(`{
+		"script": "def transform(fields):\n    return {'range': range(3)}",
+		"onError": "drop"
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := &internal.Entry{Fields: map[string]interface{}{"MESSAGE": "hello"}}
+	if err := format(entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Fields != nil {
+		t.Errorf("got %v, expected the entry to be dropped", entry.Fields)
+	}
+}
+
+func TestScriptLimitsResultSize(t *testing.T) {
+	format, err := NewScriptFormatter(json.RawMessage(`{
+		"script": "def transform(fields):\n    return [fields, {'big': 'x' * 2000}]",
+		"maxResultBytes": 1000,
+		"onError": "drop"
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := &internal.Entry{Fields: map[string]interface{}{"MESSAGE": "hello"}}
+	if err := format(entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Fields != nil {
+		t.Errorf("got %v, expected the entry to be dropped", entry.Fields)
+	}
+}
@@ -0,0 +1,127 @@
+package formatters
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/wryun/journalship/internal"
+)
+
+var syslogSeverityNames = []string{
+	"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug",
+}
+
+// otelSeverityNumbers follows the syslog mapping in the appendix of
+// the OpenTelemetry log data model.
+var otelSeverityNumbers = []int{21, 19, 18, 17, 13, 10, 9, 5}
+
+var syslogFacilityNames = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+// syslogCode handles both the raw journal strings and values which
+// have already been through the convert formatter.
+func syslogCode(value interface{}, names []string) (int, bool) {
+	var code int
+	switch v := value.(type) {
+	case string:
+		var err error
+		if code, err = strconv.Atoi(strings.TrimSpace(v)); err != nil {
+			return 0, false
+		}
+	case float64:
+		code = int(v)
+	case int64:
+		code = int(v)
+	default:
+		return 0, false
+	}
+	if code < 0 || code >= len(names) {
+		return 0, false
+	}
+	return code, true
+}
+
+func NewSeverityFormatter(rawConfig json.RawMessage) (FormatEntry, error) {
+	config := struct {
+		Mapping            string  `json:"mapping"`
+		PriorityPath       string  `json:"priorityPath"`
+		FacilityPath       string  `json:"facilityPath"`
+		OutputPath         *string `json:"outputPath"`
+		NumberOutputPath   *string `json:"numberOutputPath"`
+		FacilityOutputPath *string `json:"facilityOutputPath"`
+		DropOriginal       bool    `json:"dropOriginal"`
+	}{
+		Mapping:      "syslog",
+		PriorityPath: "PRIORITY",
+		FacilityPath: "SYSLOG_FACILITY",
+	}
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, err
+	}
+
+	// output field defaults depend on the mapping (and an empty
+	// string disables that output)
+	var outputPath, numberOutputPath, facilityOutputPath string
+	switch config.Mapping {
+	case "syslog":
+		outputPath, facilityOutputPath = "severity", "facility"
+	case "otel":
+		outputPath, numberOutputPath = "severity_text", "severity_number"
+	case "ecs":
+		outputPath, numberOutputPath, facilityOutputPath = "log.level", "log.syslog.severity.code", "log.syslog.facility.name"
+	default:
+		return nil, fmt.Errorf("unknown severity mapping %q", config.Mapping)
+	}
+	if config.OutputPath != nil {
+		outputPath = *config.OutputPath
+	}
+	if config.NumberOutputPath != nil {
+		numberOutputPath = *config.NumberOutputPath
+	}
+	if config.FacilityOutputPath != nil {
+		facilityOutputPath = *config.FacilityOutputPath
+	}
+
+	priorityPath := parseFieldPath(config.PriorityPath)
+	facilityPath := parseFieldPath(config.FacilityPath)
+	output := parseFieldPath(outputPath)
+	numberOutput := parseFieldPath(numberOutputPath)
+	facilityOutput := parseFieldPath(facilityOutputPath)
+
+	return func(entry *internal.Entry) error {
+		if value, ok := priorityPath.get(entry.Fields); ok && priorityPath != nil {
+			if code, ok := syslogCode(value, syslogSeverityNames); ok {
+				if config.DropOriginal {
+					priorityPath.delete(entry.Fields)
+				}
+				if output != nil {
+					output.set(entry.Fields, syslogSeverityNames[code])
+				}
+				if numberOutput != nil {
+					if config.Mapping == "otel" {
+						numberOutput.set(entry.Fields, otelSeverityNumbers[code])
+					} else {
+						numberOutput.set(entry.Fields, code)
+					}
+				}
+			}
+		}
+
+		if value, ok := facilityPath.get(entry.Fields); ok && facilityPath != nil {
+			if code, ok := syslogCode(value, syslogFacilityNames); ok {
+				if config.DropOriginal {
+					facilityPath.delete(entry.Fields)
+				}
+				if facilityOutput != nil {
+					facilityOutput.set(entry.Fields, syslogFacilityNames[code])
+				}
+			}
+		}
+		return nil
+	}, nil
+}
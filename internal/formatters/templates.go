@@ -0,0 +1,112 @@
+package formatters
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// templateFuncs are available in templated values (see the add
+// formatter). Where it makes sense, the value being operated on is
+// the last argument so they work in pipelines.
+func templateFuncs() template.FuncMap {
+	hostname, _ := os.Hostname()
+	return template.FuncMap{
+		"lower":      func(s interface{}) string { return strings.ToLower(toString(s)) },
+		"upper":      func(s interface{}) string { return strings.ToUpper(toString(s)) },
+		"trimSuffix": func(suffix string, s interface{}) string { return strings.TrimSuffix(toString(s), suffix) },
+		"trimPrefix": func(prefix string, s interface{}) string { return strings.TrimPrefix(toString(s), prefix) },
+		"replace": func(old, new string, s interface{}) string {
+			return strings.Replace(toString(s), old, new, -1)
+		},
+		"default": func(def interface{}, value interface{}) interface{} {
+			if value == nil || value == "" {
+				return def
+			}
+			return value
+		},
+		"hash":     func(s interface{}) string { return hashString(toString(s)) },
+		"env":      os.Getenv,
+		"hostname": func() string { return hostname },
+		// (added to every action by compileTemplate)
+		"orEmpty": func(value interface{}) interface{} {
+			if value == nil {
+				return ""
+			}
+			return value
+		},
+	}
+}
+
+func toString(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return fmt.Sprint(value)
+}
+
+func hashString(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// compileTemplate parses text as a template over an entry's fields.
+// Missing (or null) fields come out empty, rather than as text/template's
+// '<no value>'.
+func compileTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs()).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			pipeOrEmpty(t.Tree, t.Tree.Root)
+		}
+	}
+	return tmpl, nil
+}
+
+// pipeOrEmpty turns every {{ x }} under node into {{ x | orEmpty }}.
+func pipeOrEmpty(tree *parse.Tree, node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, inner := range n.Nodes {
+			pipeOrEmpty(tree, inner)
+		}
+	case *parse.ActionNode:
+		// (assignments don't output anything)
+		if len(n.Pipe.Decl) == 0 {
+			identifier := parse.NewIdentifier("orEmpty").SetTree(tree).SetPos(n.Pos)
+			n.Pipe.Cmds = append(n.Pipe.Cmds, &parse.CommandNode{
+				NodeType: parse.NodeCommand,
+				Pos:      n.Pos,
+				Args:     []parse.Node{identifier},
+			})
+		}
+	case *parse.IfNode:
+		pipeOrEmpty(tree, n.List)
+		pipeOrEmpty(tree, n.ElseList)
+	case *parse.RangeNode:
+		pipeOrEmpty(tree, n.List)
+		pipeOrEmpty(tree, n.ElseList)
+	case *parse.WithNode:
+		pipeOrEmpty(tree, n.List)
+		pipeOrEmpty(tree, n.ElseList)
+	}
+}
+
+func executeTemplate(tmpl *template.Template, fields map[string]interface{}) (string, error) {
+	var result strings.Builder
+	err := tmpl.Execute(&result, fields)
+	return result.String(), err
+}
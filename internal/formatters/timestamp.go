@@ -0,0 +1,247 @@
+package formatters
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wryun/journalship/internal"
+)
+
+var namedLayouts = map[string]string{
+	"ANSIC":       time.ANSIC,
+	"UnixDate":    time.UnixDate,
+	"RubyDate":    time.RubyDate,
+	"RFC822":      time.RFC822,
+	"RFC822Z":     time.RFC822Z,
+	"RFC850":      time.RFC850,
+	"RFC1123":     time.RFC1123,
+	"RFC1123Z":    time.RFC1123Z,
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"Kitchen":     time.Kitchen,
+	"Stamp":       time.Stamp,
+	"StampMilli":  time.StampMilli,
+	"StampMicro":  time.StampMicro,
+	"StampNano":   time.StampNano,
+}
+
+var epochUnits = map[string]time.Duration{
+	"epoch":       time.Second,
+	"epochMillis": time.Millisecond,
+	"epochMicros": time.Microsecond,
+	"epochNanos":  time.Nanosecond,
+}
+
+var strftimeDirectives = map[byte]string{
+	'a': "Mon",
+	'A': "Monday",
+	'b': "Jan",
+	'B': "January",
+	'd': "02",
+	'e': "_2",
+	'f': "000000",
+	'F': "2006-01-02",
+	'H': "15",
+	'I': "03",
+	'j': "002",
+	'm': "01",
+	'M': "04",
+	'p': "PM",
+	'S': "05",
+	'T': "15:04:05",
+	'y': "06",
+	'Y': "2006",
+	'z': "-0700",
+	'Z': "MST",
+	'%': "%",
+}
+
+// timeFormat is either an epoch unit or a Go layout. Formats can be
+// specified as a Go layout name (RFC3339), an epoch unit
+// (epochMillis), a strftime format (anything containing a %) or
+// a literal Go layout.
+type timeFormat struct {
+	layout    string
+	epochUnit time.Duration
+}
+
+func parseTimeFormat(format string) (timeFormat, error) {
+	if layout, ok := namedLayouts[format]; ok {
+		return timeFormat{layout: layout}, nil
+	}
+	if unit, ok := epochUnits[format]; ok {
+		return timeFormat{epochUnit: unit}, nil
+	}
+	if strings.Contains(format, "%") {
+		layout, err := strftimeToLayout(format)
+		return timeFormat{layout: layout}, err
+	}
+	return timeFormat{layout: format}, nil
+}
+
+func strftimeToLayout(format string) (string, error) {
+	var layout strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			layout.WriteByte(format[i])
+			continue
+		}
+		i++
+		if i == len(format) {
+			return "", fmt.Errorf("trailing %% in time format %q", format)
+		}
+		directive, ok := strftimeDirectives[format[i]]
+		if !ok {
+			return "", fmt.Errorf("unsupported directive %%%c in time format %q", format[i], format)
+		}
+		layout.WriteString(directive)
+	}
+	return layout.String(), nil
+}
+
+func (tf timeFormat) parse(value interface{}, location *time.Location) (time.Time, error) {
+	if tf.epochUnit != 0 {
+		var epoch float64
+		switch v := value.(type) {
+		case float64:
+			epoch = v
+		case string:
+			var err error
+			if epoch, err = strconv.ParseFloat(strings.TrimSpace(v), 64); err != nil {
+				return time.Time{}, err
+			}
+		default:
+			return time.Time{}, fmt.Errorf("unable to parse %T as epoch time", value)
+		}
+		whole, frac := math.Modf(epoch)
+		return time.Unix(0, 0).Add(time.Duration(whole)*tf.epochUnit + time.Duration(frac*float64(tf.epochUnit))), nil
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return time.Time{}, fmt.Errorf("unable to parse %T as time", value)
+	}
+	return time.ParseInLocation(tf.layout, s, location)
+}
+
+func (tf timeFormat) format(t time.Time) interface{} {
+	if tf.epochUnit != 0 {
+		return t.UnixNano() / int64(tf.epochUnit)
+	}
+	return t.Format(tf.layout)
+}
+
+// NewTimestampFormatter parses the application's own idea of when
+// something happened out of an entry. By default, it uses the
+// _SOURCE_REALTIME_TIMESTAMP that journald records for messages
+// with a trusted source timestamp.
+func NewTimestampFormatter(rawConfig json.RawMessage) (FormatEntry, error) {
+	config := struct {
+		InputPath      string   `json:"inputPath"`
+		Formats        []string `json:"formats"`
+		Timezone       string   `json:"timezone"`
+		OutputPath     string   `json:"outputPath"`
+		OutputFormat   string   `json:"outputFormat"`
+		OutputTimezone string   `json:"outputTimezone"`
+		FallbackPath   string   `json:"fallbackPath"`
+		errorPolicyConfig
+	}{
+		InputPath:      "_SOURCE_REALTIME_TIMESTAMP",
+		Formats:        []string{"epochMicros"},
+		Timezone:       "UTC",
+		OutputPath:     "TIME",
+		OutputFormat:   "RFC3339Nano",
+		OutputTimezone: "UTC",
+		FallbackPath:   "TIME",
+	}
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, err
+	}
+
+	if config.InputPath == "" || config.OutputPath == "" {
+		return nil, fmt.Errorf("must specify inputPath and outputPath for timestamp")
+	}
+
+	inputFormats := make([]timeFormat, 0, len(config.Formats))
+	for _, format := range config.Formats {
+		tf, err := parseTimeFormat(format)
+		if err != nil {
+			return nil, err
+		}
+		inputFormats = append(inputFormats, tf)
+	}
+	if len(inputFormats) == 0 {
+		return nil, fmt.Errorf("must specify at least one time format")
+	}
+	outputFormat, err := parseTimeFormat(config.OutputFormat)
+	if err != nil {
+		return nil, err
+	}
+	location, err := time.LoadLocation(config.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	outputLocation, err := time.LoadLocation(config.OutputTimezone)
+	if err != nil {
+		return nil, err
+	}
+	onError, err := newErrorPolicy("timestamp", config.errorPolicyConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	inputPath := parseFieldPath(config.InputPath)
+	outputPath := parseFieldPath(config.OutputPath)
+	fallbackPath := parseFieldPath(config.FallbackPath)
+	// The reader always writes the journal time as RFC3339Nano.
+	fallbackFormat := timeFormat{layout: time.RFC3339Nano}
+
+	parse := func(value interface{}) (time.Time, error) {
+		var err error
+		for _, tf := range inputFormats {
+			var t time.Time
+			if t, err = tf.parse(value, location); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, err
+	}
+
+	// falling back to the journal time (rather than leaving the output
+	// unset) means the output field is always in a consistent format.
+	useFallback := func(entry *internal.Entry) {
+		if fallbackPath == nil {
+			return
+		}
+		value, ok := fallbackPath.get(entry.Fields)
+		if !ok {
+			return
+		}
+		if t, err := fallbackFormat.parse(value, time.UTC); err == nil {
+			outputPath.set(entry.Fields, outputFormat.format(t.In(outputLocation)))
+		}
+	}
+
+	return func(entry *internal.Entry) error {
+		value, ok := inputPath.get(entry.Fields)
+		if !ok {
+			// Nothing to parse isn't an error; most entries won't have
+			// an application timestamp.
+			useFallback(entry)
+			return nil
+		}
+
+		t, err := parse(value)
+		if err != nil {
+			useFallback(entry)
+			return onError.handle(entry, fmt.Errorf("unable to parse %s as time: %s", inputPath, err))
+		}
+
+		outputPath.set(entry.Fields, outputFormat.format(t.In(outputLocation)))
+		return nil
+	}, nil
+}
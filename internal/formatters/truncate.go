@@ -0,0 +1,167 @@
+package formatters
+
+import (
+	"encoding/json"
+	"errors"
+	"unicode/utf8"
+
+	"github.com/wryun/journalship/internal"
+	"github.com/wryun/journalship/internal/keyring"
+)
+
+// Strings smaller than this aren't worth truncating further when
+// trying to squeeze an entry into a chunk.
+const minTruncateBytes = 64
+
+// truncateUTF8 cuts s to at most n bytes without splitting a rune.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// walkStrings calls fn on every string leaf of value (with its path),
+// replacing the leaf with the result.
+func walkStrings(value interface{}, path fieldPath, fn func(fieldPath, string) string) interface{} {
+	switch v := value.(type) {
+	case string:
+		return fn(path, v)
+	case map[string]interface{}:
+		for k, inner := range v {
+			v[k] = walkStrings(inner, append(path[:len(path):len(path)], k), fn)
+		}
+	case []interface{}:
+		for i, inner := range v {
+			v[i] = walkStrings(inner, path, fn)
+		}
+	}
+	return value
+}
+
+// markTruncated records the original size of a truncated field in
+// the marker map (keeping the first size if truncated repeatedly).
+func markTruncated(fields map[string]interface{}, marker fieldPath, path fieldPath, size int) {
+	markers, ok := marker.get(fields)
+	markerMap, isMap := markers.(map[string]interface{})
+	if !ok || !isMap {
+		markerMap = make(map[string]interface{})
+		marker.set(fields, markerMap)
+	}
+	if _, exists := markerMap[path.String()]; !exists {
+		markerMap[path.String()] = size
+	}
+}
+
+// walkPlainStrings is walkStrings, but leaves encrypted envelopes
+// alone (cutting them short would make them impossible to decrypt).
+func walkPlainStrings(value interface{}, path fieldPath, fn func(fieldPath, string) string) interface{} {
+	if keyring.IsEnvelope(value) {
+		return value
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, inner := range v {
+			v[k] = walkPlainStrings(inner, append(path[:len(path):len(path)], k), fn)
+		}
+		return value
+	case []interface{}:
+		for i, inner := range v {
+			v[i] = walkPlainStrings(inner, path, fn)
+		}
+		return value
+	}
+	return walkStrings(value, path, fn)
+}
+
+// TruncateLargest halves the largest string in an entry, returning
+// false if there was nothing big enough to bother. The transformer
+// uses this when an entry won't fit in an empty output chunk. The
+// original size is recorded at markerPath, as for truncate.
+func TruncateLargest(fields map[string]interface{}, markerPath string) bool {
+	var largestPath fieldPath
+	largestSize := 0
+	walkPlainStrings(fields, nil, func(path fieldPath, s string) string {
+		if len(s) > largestSize {
+			largestPath, largestSize = path, len(s)
+		}
+		return s
+	})
+	if largestSize < minTruncateBytes {
+		return false
+	}
+
+	// (second walk rather than get/set since it might be in a list)
+	done := false
+	walkPlainStrings(fields, nil, func(path fieldPath, s string) string {
+		if done || len(s) != largestSize || path.String() != largestPath.String() {
+			return s
+		}
+		done = true
+		return truncateUTF8(s, largestSize/2)
+	})
+	markTruncated(fields, parseFieldPath(markerPath), largestPath, largestSize)
+	return true
+}
+
+func NewTruncateFormatter(rawConfig json.RawMessage) (FormatEntry, error) {
+	config := struct {
+		Fields     []string `json:"fields"`
+		MaxBytes   int      `json:"maxBytes"`
+		MarkerPath string   `json:"markerPath"`
+	}{
+		MarkerPath: "truncated",
+	}
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, err
+	}
+
+	if config.MaxBytes <= 0 {
+		return nil, errors.New("must specify maxBytes for truncate")
+	}
+	if config.MarkerPath == "" {
+		return nil, errors.New("must specify markerPath for truncate")
+	}
+
+	marker := parseFieldPath(config.MarkerPath)
+	paths := make([]fieldPath, 0, len(config.Fields))
+	for _, field := range config.Fields {
+		paths = append(paths, parseFieldPath(field))
+	}
+	counts := counters("truncate")
+
+	return func(entry *internal.Entry) error {
+		var truncated []fieldPath
+		var sizes []int
+		truncate := func(path fieldPath, s string) string {
+			if len(s) <= config.MaxBytes {
+				return s
+			}
+			truncated = append(truncated, path)
+			sizes = append(sizes, len(s))
+			return truncateUTF8(s, config.MaxBytes)
+		}
+
+		if len(paths) == 0 {
+			walkPlainStrings(entry.Fields, nil, truncate)
+		}
+		for _, path := range paths {
+			if value, ok := path.get(entry.Fields); ok {
+				path.set(entry.Fields, walkPlainStrings(value, path, truncate))
+			}
+		}
+
+		// marked afterwards so we don't walk into our own marker
+		for i, path := range truncated {
+			markTruncated(entry.Fields, marker, path, sizes[i])
+		}
+		if len(truncated) != 0 {
+			counts.Add("entries", 1)
+			counts.Add("fields", int64(len(truncated)))
+		}
+		return nil
+	}, nil
+}
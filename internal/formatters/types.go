@@ -2,6 +2,7 @@ package formatters
 
 import (
 	"encoding/json"
+	"log"
 
 	"github.com/wryun/journalship/internal"
 )
@@ -9,9 +10,57 @@ import (
 type FormatEntry func(*internal.Entry) error
 type FormatConstructor func(json.RawMessage) (FormatEntry, error)
 
+// FormatBatch is for formatters which need to see all the entries
+// from an InputChunk at once (e.g. to save on round trips). Like
+// FormatEntry, they can drop entries or emit extras.
+type FormatBatch func([]*internal.Entry) error
+type BatchFormatConstructor func(json.RawMessage) (FormatBatch, error)
+
+// EachEntry lets a FormatEntry be used as a FormatBatch. Errors for
+// individual entries are only logged, since they don't affect the
+// rest of the batch.
+func EachEntry(formatEntry FormatEntry) FormatBatch {
+	return func(entries []*internal.Entry) error {
+		for _, entry := range entries {
+			if err := formatEntry(entry); err != nil {
+				log.Println(err)
+			}
+		}
+		return nil
+	}
+}
+
 var Formatters = map[string]FormatConstructor{
-	"jsone":     NewJsoneFormatter,
-	"unmarshal": NewUnmarshalFormatter,
-	"add":       NewAddFormatter,
-	"lowercase": NewLowercaseFormatter,
+	"jsone":       NewJsoneFormatter,
+	"unmarshal":   NewUnmarshalFormatter,
+	"add":         NewAddFormatter,
+	"lowercase":   NewLowercaseFormatter,
+	"logfmt":      NewLogfmtFormatter,
+	"kv":          NewLogfmtFormatter,
+	"timestamp":   NewTimestampFormatter,
+	"convert":     NewConvertFormatter,
+	"severity":    NewSeverityFormatter,
+	"redact":      NewRedactFormatter,
+	"encrypt":     NewEncryptFormatter,
+	"truncate":    NewTruncateFormatter,
+	"sanitize":    NewSanitizeFormatter,
+	"dedup":       NewDedupFormatter,
+	"ratelimit":   NewRateLimitFormatter,
+	"hostinfo":    NewHostInfoFormatter,
+	"container":   NewContainerFormatter,
+	"kubernetes":  NewKubernetesFormatter,
+	"script":      NewScriptFormatter,
+	"lookup":      NewLookupFormatter,
+	"flatten":     NewFlattenFormatter,
+	"unflatten":   NewUnflattenFormatter,
+	"otel":        NewOtelFormatter,
+	"ecs":         NewEcsFormatter,
+	"fingerprint": NewFingerprintFormatter,
+	"audit":       NewAuditFormatter,
+	"kernel":      NewKernelFormatter,
+	"validate":    NewValidateFormatter,
+}
+
+var BatchFormatters = map[string]BatchFormatConstructor{
+	"exec": NewExecFormatter,
 }
@@ -3,14 +3,59 @@ package formatters
 import (
 	"encoding/json"
 	"errors"
-	"strings"
+	"fmt"
+	"net/url"
 
+	"github.com/ghodss/yaml"
 	"github.com/wryun/journalship/internal"
 )
 
+type decodeFn func(string) (interface{}, error)
+
+var decoders = map[string]decodeFn{
+	"json": func(input string) (interface{}, error) {
+		var result interface{}
+		err := json.Unmarshal([]byte(input), &result)
+		return result, err
+	},
+	"yaml": func(input string) (interface{}, error) {
+		var result interface{}
+		err := yaml.Unmarshal([]byte(input), &result)
+		return result, err
+	},
+	"logfmt": func(input string) (interface{}, error) {
+		parser := kvParser{pairSeparator: " ", kvSeparator: "=", quoteChars: `"`}
+		return parser.parse(input)
+	},
+	"urlencoded": func(input string) (interface{}, error) {
+		values, err := url.ParseQuery(input)
+		if err != nil {
+			return nil, err
+		}
+		result := make(map[string]interface{}, len(values))
+		for k, vs := range values {
+			if len(vs) == 1 {
+				result[k] = vs[0]
+				continue
+			}
+			list := make([]interface{}, len(vs))
+			for i, v := range vs {
+				list[i] = v
+			}
+			result[k] = list
+		}
+		return result, nil
+	},
+}
+
 func NewUnmarshalFormatter(rawConfig json.RawMessage) (FormatEntry, error) {
 	var config struct {
-		InputPath string `json:"inputPath"`
+		InputPath    string `json:"inputPath"`
+		OutputPath   string `json:"outputPath"`
+		KeepOriginal bool   `json:"keepOriginal"`
+		Encoding     string `json:"encoding"`
+		MaxInputSize int    `json:"maxInputSize"`
+		errorPolicyConfig
 	}
 	if err := json.Unmarshal(rawConfig, &config); err != nil {
 		return nil, err
@@ -19,41 +64,53 @@ func NewUnmarshalFormatter(rawConfig json.RawMessage) (FormatEntry, error) {
 	if config.InputPath == "" {
 		return nil, errors.New("must specify field to unmarshal")
 	}
+	if config.Encoding == "" {
+		config.Encoding = "json"
+	}
+	decode, ok := decoders[config.Encoding]
+	if !ok {
+		return nil, fmt.Errorf("unknown unmarshal encoding %q", config.Encoding)
+	}
+	onError, err := newErrorPolicy("unmarshal", config.errorPolicyConfig)
+	if err != nil {
+		return nil, err
+	}
 
-	inputPath := strings.Split(config.InputPath, ".")
+	inputPath := parseFieldPath(config.InputPath)
+	outputPath := parseFieldPath(config.OutputPath)
 
 	return func(entry *internal.Entry) error {
-		// TODO not sure about error handling here.
-		// At the moment, we simply bail if any issues, but user
-		// then misses what they've done wrong...
-		// Need debugging log mode?
-		var something interface{}
-		something = entry.Fields
-		var fieldMap map[string]interface{}
-		var f string
-		for _, f = range inputPath {
-			var ok bool
-			fieldMap, ok = something.(map[string]interface{})
-			if !ok {
-				return nil
-			}
-			if something, ok = fieldMap[f]; !ok {
-				return nil
-			}
+		something, ok := inputPath.get(entry.Fields)
+		if !ok {
+			return nil
 		}
 		input, ok := something.(string)
 		if !ok {
 			return nil
 		}
 
-		delete(fieldMap, f)
-		err := json.Unmarshal([]byte(input), &entry.Fields)
+		if config.MaxInputSize > 0 && len(input) > config.MaxInputSize {
+			return onError.handle(entry, fmt.Errorf("%s is %d bytes (more than maxInputSize)", inputPath, len(input)))
+		}
+
+		decoded, err := decode(input)
 		if err != nil {
-			fieldMap[f] = something
-			return nil
+			return onError.handle(entry, fmt.Errorf("unable to decode %s as %s: %s", inputPath, config.Encoding, err))
+		}
+
+		decodedMap, isMap := decoded.(map[string]interface{})
+		if !isMap && outputPath == nil {
+			return onError.handle(entry, fmt.Errorf("%s decoded to a non-object (and no outputPath set)", inputPath))
 		}
 
-		// TODO support outputPath?
+		if !config.KeepOriginal {
+			inputPath.delete(entry.Fields)
+		}
+		if isMap {
+			outputPath.merge(entry.Fields, decodedMap)
+		} else {
+			outputPath.set(entry.Fields, decoded)
+		}
 		return nil
 	}, nil
 }
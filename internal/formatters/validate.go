@@ -0,0 +1,534 @@
+package formatters
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ghodss/yaml"
+	"github.com/wryun/journalship/internal"
+)
+
+// maxSchemaDepth stops recursive $refs going on forever.
+const maxSchemaDepth = 64
+
+// jsonSchema is enough of JSON Schema for checking log entries: the
+// structural and value keywords (type, properties, items, enum,
+// minimum, pattern, anyOf, $ref, etc), but not format or remote refs.
+type jsonSchema struct {
+	root     interface{}
+	patterns map[string]*regexp.Regexp
+}
+
+type schemaError struct {
+	// pointer says where the problem is (as a JSON pointer); strip is
+	// the (object only) path to remove to get rid of it.
+	pointer string
+	strip   fieldPath
+	message string
+}
+
+func (e schemaError) String() string {
+	if e.pointer == "" {
+		return e.message
+	}
+	return e.pointer + ": " + e.message
+}
+
+func loadJSONSchema(fileName string) (*jsonSchema, error) {
+	contents, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var root interface{}
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(contents, &root)
+	default:
+		err = json.Unmarshal(contents, &root)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch root.(type) {
+	case map[string]interface{}, bool:
+	default:
+		return nil, errors.New("schema must be an object or boolean")
+	}
+
+	s := &jsonSchema{root: root, patterns: make(map[string]*regexp.Regexp)}
+	if err := s.prepare(root); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// prepare compiles every pattern and checks every $ref resolves, so
+// mistakes in the schema are found at startup.
+func (s *jsonSchema) prepare(schema interface{}) error {
+	switch v := schema.(type) {
+	case map[string]interface{}:
+		for k, inner := range v {
+			switch k {
+			case "pattern":
+				if pattern, ok := inner.(string); ok {
+					if err := s.compile(pattern); err != nil {
+						return err
+					}
+				}
+			case "patternProperties":
+				if patterns, ok := inner.(map[string]interface{}); ok {
+					for pattern := range patterns {
+						if err := s.compile(pattern); err != nil {
+							return err
+						}
+					}
+				}
+			case "$ref":
+				if ref, ok := inner.(string); ok {
+					if _, err := s.resolve(ref); err != nil {
+						return err
+					}
+				}
+			}
+			if err := s.prepare(inner); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, inner := range v {
+			if err := s.prepare(inner); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *jsonSchema) compile(pattern string) error {
+	if _, ok := s.patterns[pattern]; ok {
+		return nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("schema pattern %q: %s", pattern, err)
+	}
+	s.patterns[pattern] = re
+	return nil
+}
+
+// resolve handles local refs (#, #/definitions/x, #/$defs/x, ...).
+func (s *jsonSchema) resolve(ref string) (interface{}, error) {
+	if ref != "#" && !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("unsupported $ref %q (only local refs are supported)", ref)
+	}
+	current := s.root
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#"), "/")[1:] {
+		token = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+		switch v := current.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if current, ok = v[token]; !ok {
+				return nil, fmt.Errorf("unresolvable $ref %q", ref)
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, fmt.Errorf("unresolvable $ref %q", ref)
+			}
+			current = v[i]
+		default:
+			return nil, fmt.Errorf("unresolvable $ref %q", ref)
+		}
+	}
+	return current, nil
+}
+
+func (s *jsonSchema) validate(value interface{}) []schemaError {
+	var errs []schemaError
+	s.check(s.root, jsonCompatible(value), "", fieldPath{}, true, 0, &errs)
+	return errs
+}
+
+func schemaNumber(value interface{}) (float64, bool) {
+	f, ok := value.(float64)
+	return f, ok
+}
+
+func schemaType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func schemaTypeMatches(want string, value interface{}) bool {
+	got := schemaType(value)
+	return got == want || (want == "number" && got == "integer")
+}
+
+// check adds any problems with value to errs. strip only grows while
+// we're in objects (you can't remove part of an array with a path).
+func (s *jsonSchema) check(schema interface{}, value interface{}, pointer string, strip fieldPath, inObject bool, depth int, errs *[]schemaError) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, schemaError{pointer, strip, fmt.Sprintf(format, args...)})
+	}
+	if depth > maxSchemaDepth {
+		fail("schema nested too deeply")
+		return
+	}
+
+	if allowed, ok := schema.(bool); ok {
+		if !allowed {
+			fail("not allowed")
+		}
+		return
+	}
+	keywords, ok := schema.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	if ref, ok := keywords["$ref"].(string); ok {
+		resolved, err := s.resolve(ref)
+		if err != nil {
+			fail("%s", err)
+			return
+		}
+		s.check(resolved, value, pointer, strip, inObject, depth+1, errs)
+	}
+
+	// child works out where a property/item is (for reporting and
+	// stripping).
+	child := func(name string, isProperty bool) (string, fieldPath, bool) {
+		childPointer := pointer + "/" + strings.Replace(strings.Replace(name, "~", "~0", -1), "/", "~1", -1)
+		if inObject && isProperty {
+			childStrip := make(fieldPath, len(strip)+1)
+			copy(childStrip, strip)
+			childStrip[len(strip)] = name
+			return childPointer, childStrip, true
+		}
+		return childPointer, strip, false
+	}
+	// matches checks against a subschema without reporting anything.
+	matches := func(subschema interface{}) bool {
+		var subErrs []schemaError
+		s.check(subschema, value, pointer, strip, inObject, depth+1, &subErrs)
+		return len(subErrs) == 0
+	}
+
+	switch types := keywords["type"].(type) {
+	case string:
+		if !schemaTypeMatches(types, value) {
+			fail("expected %s, got %s", types, schemaType(value))
+			return
+		}
+	case []interface{}:
+		matched := false
+		names := make([]string, 0, len(types))
+		for _, t := range types {
+			name, _ := t.(string)
+			names = append(names, name)
+			matched = matched || schemaTypeMatches(name, value)
+		}
+		if !matched {
+			fail("expected one of %s, got %s", strings.Join(names, ", "), schemaType(value))
+			return
+		}
+	}
+
+	if allowed, ok := keywords["enum"].([]interface{}); ok {
+		found := false
+		for _, option := range allowed {
+			found = found || reflect.DeepEqual(option, value)
+		}
+		if !found {
+			fail("not one of the allowed values")
+		}
+	}
+	if constant, ok := keywords["const"]; ok && !reflect.DeepEqual(constant, value) {
+		fail("must be %v", constant)
+	}
+
+	if n, ok := schemaNumber(value); ok {
+		if min, ok := schemaNumber(keywords["minimum"]); ok && n < min {
+			fail("must be at least %v", min)
+		}
+		if max, ok := schemaNumber(keywords["maximum"]); ok && n > max {
+			fail("must be at most %v", max)
+		}
+		if min, ok := schemaNumber(keywords["exclusiveMinimum"]); ok && n <= min {
+			fail("must be more than %v", min)
+		}
+		if max, ok := schemaNumber(keywords["exclusiveMaximum"]); ok && n >= max {
+			fail("must be less than %v", max)
+		}
+		if multiple, ok := schemaNumber(keywords["multipleOf"]); ok && multiple > 0 {
+			if q := n / multiple; q != math.Trunc(q) {
+				fail("must be a multiple of %v", multiple)
+			}
+		}
+	}
+
+	if str, ok := value.(string); ok {
+		length := float64(utf8.RuneCountInString(str))
+		if min, ok := schemaNumber(keywords["minLength"]); ok && length < min {
+			fail("must be at least %v characters", min)
+		}
+		if max, ok := schemaNumber(keywords["maxLength"]); ok && length > max {
+			fail("must be at most %v characters", max)
+		}
+		if pattern, ok := keywords["pattern"].(string); ok && !s.patterns[pattern].MatchString(str) {
+			fail("must match %s", pattern)
+		}
+	}
+
+	if object, ok := value.(map[string]interface{}); ok {
+		count := float64(len(object))
+		if min, ok := schemaNumber(keywords["minProperties"]); ok && count < min {
+			fail("must have at least %v properties", min)
+		}
+		if max, ok := schemaNumber(keywords["maxProperties"]); ok && count > max {
+			fail("must have at most %v properties", max)
+		}
+		if required, ok := keywords["required"].([]interface{}); ok {
+			for _, name := range required {
+				if k, ok := name.(string); ok {
+					if _, present := object[k]; !present {
+						fail("missing required property %s", k)
+					}
+				}
+			}
+		}
+
+		properties, _ := keywords["properties"].(map[string]interface{})
+		patternProperties, _ := keywords["patternProperties"].(map[string]interface{})
+		additional, hasAdditional := keywords["additionalProperties"]
+		// (sorted so errors come out in a consistent order)
+		keys := make([]string, 0, len(object))
+		for k := range object {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			childPointer, childStrip, childInObject := child(k, true)
+			matched := false
+			if subschema, ok := properties[k]; ok {
+				matched = true
+				s.check(subschema, object[k], childPointer, childStrip, childInObject, depth+1, errs)
+			}
+			for pattern, subschema := range patternProperties {
+				if s.patterns[pattern].MatchString(k) {
+					matched = true
+					s.check(subschema, object[k], childPointer, childStrip, childInObject, depth+1, errs)
+				}
+			}
+			if !matched && hasAdditional {
+				if additional == false {
+					*errs = append(*errs, schemaError{childPointer, childStrip, "additional property not allowed"})
+				} else {
+					s.check(additional, object[k], childPointer, childStrip, childInObject, depth+1, errs)
+				}
+			}
+		}
+	}
+
+	if array, ok := value.([]interface{}); ok {
+		count := float64(len(array))
+		if min, ok := schemaNumber(keywords["minItems"]); ok && count < min {
+			fail("must have at least %v items", min)
+		}
+		if max, ok := schemaNumber(keywords["maxItems"]); ok && count > max {
+			fail("must have at most %v items", max)
+		}
+		if unique, _ := keywords["uniqueItems"].(bool); unique {
+			for i := range array {
+				for j := i + 1; j < len(array); j++ {
+					if reflect.DeepEqual(array[i], array[j]) {
+						fail("items %d and %d are the same", i, j)
+					}
+				}
+			}
+		}
+		switch items := keywords["items"].(type) {
+		case []interface{}:
+			for i, subschema := range items {
+				if i < len(array) {
+					childPointer, childStrip, _ := child(strconv.Itoa(i), false)
+					s.check(subschema, array[i], childPointer, childStrip, false, depth+1, errs)
+				}
+			}
+		case map[string]interface{}, bool:
+			for i, item := range array {
+				childPointer, childStrip, _ := child(strconv.Itoa(i), false)
+				s.check(items, item, childPointer, childStrip, false, depth+1, errs)
+			}
+		}
+	}
+
+	if all, ok := keywords["allOf"].([]interface{}); ok {
+		for _, subschema := range all {
+			s.check(subschema, value, pointer, strip, inObject, depth+1, errs)
+		}
+	}
+	if anyOf, ok := keywords["anyOf"].([]interface{}); ok {
+		matched := false
+		for _, subschema := range anyOf {
+			if matches(subschema) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("does not match any of anyOf")
+		}
+	}
+	if oneOf, ok := keywords["oneOf"].([]interface{}); ok {
+		count := 0
+		for _, subschema := range oneOf {
+			if matches(subschema) {
+				count++
+			}
+		}
+		if count != 1 {
+			fail("matches %d of oneOf (must match exactly one)", count)
+		}
+	}
+	if not, ok := keywords["not"]; ok && matches(not) {
+		fail("must not match schema in not")
+	}
+	if condition, ok := keywords["if"]; ok {
+		if matches(condition) {
+			if then, ok := keywords["then"]; ok {
+				s.check(then, value, pointer, strip, inObject, depth+1, errs)
+			}
+		} else if otherwise, ok := keywords["else"]; ok {
+			s.check(otherwise, value, pointer, strip, inObject, depth+1, errs)
+		}
+	}
+}
+
+// NewValidateFormatter checks entries (or the object at path) against
+// a JSON Schema. Invalid entries are handled by onError (by default
+// tag, or e.g. deadLetter, with the validation errors attached), or
+// with onError strip, the offending fields are removed (a bad array
+// item takes the whole array with it), and anything still invalid
+// afterwards (e.g. missing required fields, which can't be fixed that
+// way) is tagged instead.
+func NewValidateFormatter(rawConfig json.RawMessage) (FormatEntry, error) {
+	config := struct {
+		errorPolicyConfig
+		SchemaFile string `json:"schemaFile"`
+		Name       string `json:"name"`
+		Path       string `json:"path"`
+		MaxErrors  int    `json:"maxErrors"`
+	}{
+		errorPolicyConfig: errorPolicyConfig{OnError: "tag"},
+		MaxErrors:         10,
+	}
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, err
+	}
+
+	if config.SchemaFile == "" {
+		return nil, errors.New("must specify schemaFile for validate")
+	}
+	if config.Name == "" {
+		config.Name = strings.TrimSuffix(filepath.Base(config.SchemaFile), filepath.Ext(config.SchemaFile))
+	}
+	schema, err := loadJSONSchema(config.SchemaFile)
+	if err != nil {
+		return nil, fmt.Errorf("schema %s: %s", config.SchemaFile, err)
+	}
+
+	strip := config.OnError == "strip"
+	policyConfig := config.errorPolicyConfig
+	if strip {
+		policyConfig.OnError = "tag"
+	}
+	onError, err := newErrorPolicy("validate "+config.Name, policyConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	path := parseFieldPath(config.Path)
+	counts := counters("validate." + config.Name)
+
+	describe := func(errs []schemaError) error {
+		messages := make([]string, 0, len(errs))
+		for i, e := range errs {
+			if config.MaxErrors > 0 && i == config.MaxErrors {
+				messages = append(messages, fmt.Sprintf("(and %d more)", len(errs)-i))
+				break
+			}
+			messages = append(messages, e.String())
+		}
+		return errors.New(strings.Join(messages, "; "))
+	}
+
+	return func(entry *internal.Entry) error {
+		target := entry.Fields
+		if path != nil {
+			value, ok := path.get(entry.Fields)
+			if !ok {
+				counts.Add("failed", 1)
+				return onError.handle(entry, fmt.Errorf("%s is missing", path))
+			}
+			if target, ok = value.(map[string]interface{}); !ok {
+				counts.Add("failed", 1)
+				return onError.handle(entry, fmt.Errorf("%s is not an object", path))
+			}
+		}
+
+		errs := schema.validate(target)
+		if len(errs) == 0 {
+			counts.Add("passed", 1)
+			return nil
+		}
+		counts.Add("failed", 1)
+		if !strip {
+			return onError.handle(entry, describe(errs))
+		}
+
+		stripped := false
+		for _, e := range errs {
+			if len(e.strip) != 0 {
+				e.strip.delete(target)
+				counts.Add("stripped", 1)
+				stripped = true
+			}
+		}
+		// Removing things can cause new problems (e.g. a required
+		// field with a bad value), so check again.
+		if stripped {
+			errs = schema.validate(target)
+		}
+		if len(errs) > 0 {
+			return onError.handle(entry, describe(errs))
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,221 @@
+package formatters
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/wryun/journalship/internal"
+)
+
+func writeSchema(t *testing.T, dir, name, schema string) string {
+	fileName := filepath.Join(dir, name+".json")
+	if err := ioutil.WriteFile(fileName, []byte(schema), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func TestJSONSchemaValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name     string
+		schema   string
+		value    map[string]interface{}
+		expected []string
+	}{
+		{
+			name:     "valid",
+			schema:   `{"type": "object", "properties": {"n": {"type": "integer"}}}`,
+			value:    map[string]interface{}{"n": int64(3)},
+			expected: nil,
+		},
+		{
+			name:     "type",
+			schema:   `{"properties": {"n": {"type": "integer"}}}`,
+			value:    map[string]interface{}{"n": "3"},
+			expected: []string{"/n: expected integer, got string"},
+		},
+		{
+			name:     "required",
+			schema:   `{"required": ["level"]}`,
+			value:    map[string]interface{}{},
+			expected: []string{"missing required property level"},
+		},
+		{
+			name:     "additionalProperties",
+			schema:   `{"properties": {"a": true}, "additionalProperties": false}`,
+			value:    map[string]interface{}{"a": 1.0, "b": 2.0},
+			expected: []string{"/b: additional property not allowed"},
+		},
+		{
+			name:     "array items",
+			schema:   `{"properties": {"tags": {"items": {"type": "string"}}}}`,
+			value:    map[string]interface{}{"tags": []interface{}{"a", 1.0}},
+			expected: []string{"/tags/1: expected string, got integer"},
+		},
+		{
+			name: "ref",
+			schema: `{
+				"properties": {"user": {"$ref": "#/$defs/user"}},
+				"$defs": {"user": {"properties": {"id": {"pattern": "^u[0-9]+$"}}}}
+			}`,
+			value:    map[string]interface{}{"user": map[string]interface{}{"id": "x1"}},
+			expected: []string{"/user/id: must match ^u[0-9]+$"},
+		},
+		{
+			name:     "anyOf match",
+			schema:   `{"properties": {"v": {"anyOf": [{"type": "string"}, {"type": "number"}]}}}`,
+			value:    map[string]interface{}{"v": 1.5},
+			expected: nil,
+		},
+		{
+			name:     "anyOf no match",
+			schema:   `{"properties": {"v": {"anyOf": [{"type": "string"}, {"type": "number"}]}}}`,
+			value:    map[string]interface{}{"v": true},
+			expected: []string{"/v: does not match any of anyOf"},
+		},
+		{
+			name:     "oneOf match",
+			schema:   `{"properties": {"v": {"oneOf": [{"type": "string"}, {"type": "integer"}]}}}`,
+			value:    map[string]interface{}{"v": "x"},
+			expected: nil,
+		},
+		{
+			name:     "oneOf several",
+			schema:   `{"properties": {"v": {"oneOf": [{"type": "number"}, {"type": "integer"}]}}}`,
+			value:    map[string]interface{}{"v": int64(2)},
+			expected: []string{"/v: matches 2 of oneOf (must match exactly one)"},
+		},
+		{
+			name:     "oneOf none",
+			schema:   `{"properties": {"v": {"oneOf": [{"type": "string"}, {"type": "integer"}]}}}`,
+			value:    map[string]interface{}{"v": nil},
+			expected: []string{"/v: matches 0 of oneOf (must match exactly one)"},
+		},
+	}
+	for _, test := range tests {
+		schema, err := loadJSONSchema(writeSchema(t, dir, "schema", test.schema))
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		var got []string
+		for _, e := range schema.validate(test.value) {
+			got = append(got, e.String())
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: got %q, expected %q", test.name, got, test.expected)
+		}
+	}
+}
+
+func TestJSONSchemaRejectsBadRefs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, schema := range []string{
+		`{"properties": {"a": {"$ref": "#/$defs/missing"}}}`,
+		`{"properties": {"a": {"$ref": "other.json#/a"}}}`,
+		`{"properties": {"a": {"pattern": "("}}}`,
+	} {
+		if _, err := loadJSONSchema(writeSchema(t, dir, "schema", schema)); err == nil {
+			t.Errorf("%s: expected an error", schema)
+		}
+	}
+}
+
+func TestValidateFormatter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	schemaFile := writeSchema(t, dir, "app", `{
+		"required": ["level"],
+		"properties": {
+			"level": {"enum": ["info", "error"]},
+			"n": {"type": "integer"},
+			"user": {"properties": {"id": {"type": "string"}}, "additionalProperties": false},
+			"tags": {"items": {"type": "string"}}
+		}
+	}`)
+
+	tests := []struct {
+		name     string
+		onError  string
+		fields   map[string]interface{}
+		expected map[string]interface{}
+	}{
+		{
+			name:     "valid",
+			fields:   map[string]interface{}{"level": "info", "n": int64(3)},
+			expected: map[string]interface{}{"level": "info", "n": int64(3)},
+		},
+		{
+			name:   "tagged by default",
+			fields: map[string]interface{}{"level": "info", "n": "three"},
+			expected: map[string]interface{}{
+				"level": "info", "n": "three",
+				"error": "validate app: /n: expected integer, got string",
+			},
+		},
+		{
+			name:    "strip nested",
+			onError: "strip",
+			fields: map[string]interface{}{
+				"level": "info",
+				"user":  map[string]interface{}{"id": "u1", "extra": true},
+			},
+			expected: map[string]interface{}{
+				"level": "info",
+				"user":  map[string]interface{}{"id": "u1"},
+			},
+		},
+		{
+			name:     "strip array",
+			onError:  "strip",
+			fields:   map[string]interface{}{"level": "info", "tags": []interface{}{"a", 1.0}},
+			expected: map[string]interface{}{"level": "info"},
+		},
+		{
+			name:    "strip then still invalid",
+			onError: "strip",
+			fields:  map[string]interface{}{"level": "bad", "n": int64(3)},
+			expected: map[string]interface{}{
+				"n":     int64(3),
+				"error": "validate app: missing required property level",
+			},
+		},
+	}
+	for _, test := range tests {
+		options := map[string]string{"schemaFile": schemaFile}
+		if test.onError != "" {
+			options["onError"] = test.onError
+		}
+		config, _ := json.Marshal(options)
+		format, err := NewValidateFormatter(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry := &internal.Entry{Fields: test.fields}
+		if err := format(entry); err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(entry.Fields, test.expected) {
+			t.Errorf("%s: got %v, expected %v", test.name, entry.Fields, test.expected)
+		}
+	}
+}
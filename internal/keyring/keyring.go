@@ -0,0 +1,135 @@
+package keyring
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/ghodss/yaml"
+)
+
+// Algorithm is recorded in each envelope so we can change it later.
+const Algorithm = "aes-gcm"
+
+// Keyring holds named AES keys. New values are always encrypted with
+// the current key, but any key in the ring can be used to decrypt, so
+// keys can be rotated by adding a new key and making it current.
+type Keyring struct {
+	current string
+	aeads   map[string]cipher.AEAD
+}
+
+// Load reads a YAML (or JSON) keyring file of the form:
+//
+//	current: key2
+//	keys:
+//	  key1: <base64 encoded 16, 24 or 32 byte key>
+//	  key2: ...
+func Load(fileName string) (*Keyring, error) {
+	contents, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var config struct {
+		Current string            `json:"current"`
+		Keys    map[string]string `json:"keys"`
+	}
+	if err := yaml.Unmarshal(contents, &config); err != nil {
+		return nil, err
+	}
+
+	kr := &Keyring{
+		current: config.Current,
+		aeads:   make(map[string]cipher.AEAD, len(config.Keys)),
+	}
+	for id, encodedKey := range config.Keys {
+		key, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %s", id, err)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %s", id, err)
+		}
+		if kr.aeads[id], err = cipher.NewGCM(block); err != nil {
+			return nil, fmt.Errorf("key %s: %s", id, err)
+		}
+	}
+	if _, ok := kr.aeads[kr.current]; !ok {
+		return nil, fmt.Errorf("current key %q not in keyring %s", kr.current, fileName)
+	}
+	return kr, nil
+}
+
+// Seal encrypts the JSON encoding of value, returning an envelope
+// suitable for putting straight back into an entry.
+func (kr *Keyring) Seal(value interface{}) (map[string]interface{}, error) {
+	plaintext, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	aead := kr.aeads[kr.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	// The key id is authenticated so it can't be swapped.
+	ciphertext := aead.Seal(nil, nonce, plaintext, []byte(kr.current))
+	return map[string]interface{}{
+		"enc":   Algorithm,
+		"kid":   kr.current,
+		"nonce": base64.StdEncoding.EncodeToString(nonce),
+		"data":  base64.StdEncoding.EncodeToString(ciphertext),
+	}, nil
+}
+
+// IsEnvelope reports whether value looks like something Seal made.
+func IsEnvelope(value interface{}) bool {
+	envelope, ok := value.(map[string]interface{})
+	if !ok || envelope["enc"] != Algorithm {
+		return false
+	}
+	_, hasKID := envelope["kid"].(string)
+	_, hasNonce := envelope["nonce"].(string)
+	_, hasData := envelope["data"].(string)
+	return len(envelope) == 4 && hasKID && hasNonce && hasData
+}
+
+// Open reverses Seal.
+func (kr *Keyring) Open(value interface{}) (interface{}, error) {
+	if !IsEnvelope(value) {
+		return nil, errors.New("not an encrypted envelope")
+	}
+	envelope := value.(map[string]interface{})
+	kid := envelope["kid"].(string)
+	aead, ok := kr.aeads[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	nonce, err := base64.StdEncoding.DecodeString(envelope["nonce"].(string))
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid nonce")
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(envelope["data"].(string))
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(kid))
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(plaintext))
+	decoder.UseNumber()
+	var result interface{}
+	err = decoder.Decode(&result)
+	return result, err
+}
@@ -26,6 +26,7 @@ package reader
 import "C"
 
 import (
+	"encoding/hex"
 	"fmt"
 	"strings"
 	"syscall"
@@ -86,6 +87,7 @@ func (j *C.sd_journal) GetCursor() (string, error) {
 	if r < 0 {
 		return "", translateError("get_cursor", r)
 	}
+	defer C.free(unsafe.Pointer(cursor))
 	return string(C.GoString(cursor)), nil
 }
 
@@ -167,6 +169,16 @@ func (j *C.sd_journal) GetFields() (map[string]interface{}, error) {
 	return fields, nil
 }
 
+func (j *C.sd_journal) GetBootID() (string, error) {
+	var usec C.uint64_t
+	var bootID C.sd_id128_t
+	r := C.sd_journal_get_monotonic_usec(j, &usec, &bootID)
+	if r < 0 {
+		return "", translateError("get_monotonic_usec", r)
+	}
+	return hex.EncodeToString(C.GoBytes(unsafe.Pointer(&bootID), C.int(unsafe.Sizeof(bootID)))), nil
+}
+
 func (j *C.sd_journal) GetRealtime() (time.Time, error) {
 	microSecs := C.uint64_t(0)
 	r := C.sd_journal_get_realtime_usec(j, &microSecs)
@@ -20,6 +20,7 @@ type Reader struct {
 	joinContainerPartial int
 	partialBuffer map[string]*internal.Entry
 	timeField string
+	entryMetadata bool
 	CursorSaver *CursorSaver
 }
 
@@ -31,6 +32,7 @@ func NewReader(rawConfig json.RawMessage) (*Reader, error) {
 		FieldNames []string `json:"fieldNames"`
 		JoinContainerPartial int `json:"joinContainerPartial"`
 		TimeField string `json:"timeField"`
+		EntryMetadata bool `json:"entryMetadata"`
 	}{
 		CursorFile: "",
 		EntriesInChunk: 1000,
@@ -78,6 +80,7 @@ func NewReader(rawConfig json.RawMessage) (*Reader, error) {
 		joinContainerPartial: config.JoinContainerPartial,
 		partialBuffer: make(map[string]*internal.Entry),
 		timeField: config.TimeField,
+		entryMetadata: config.EntryMetadata,
 		CursorSaver: newCursorSaver(config.CursorFile),
 	}, nil
 }
@@ -212,5 +215,15 @@ func (r *Reader) readEntry() (*internal.Entry, error) {
 		fields[r.timeField] = entryTime.Format(time.RFC3339Nano)
 	}
 
-	return &internal.Entry{Fields: fields}, nil
+	entry := &internal.Entry{Fields: fields}
+	if r.entryMetadata {
+		if entry.Cursor, err = r.journal.GetCursor(); err != nil {
+			return nil, err
+		}
+		if entry.BootID, err = r.journal.GetBootID(); err != nil {
+			return nil, err
+		}
+	}
+
+	return entry, nil
 }
\ No newline at end of file
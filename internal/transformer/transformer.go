@@ -2,34 +2,50 @@ package transformer
 
 import (
 	"encoding/json"
+	"errors"
+	"expvar"
 	"log"
 	"time"
 
+	"github.com/wryun/journalship/internal"
 	"github.com/wryun/journalship/internal/formatters"
 	"github.com/wryun/journalship/internal/reader"
 	"github.com/wryun/journalship/internal/shippers"
 )
 
+var counts = expvar.NewMap("transformer")
+
 type Transformer struct {
-	newOutputChunk func() shippers.OutputChunk
-	formatFns      []formatters.FormatEntry
-	maxLogDelay    time.Duration
+	newOutputChunk    func() shippers.OutputChunk
+	formatFns         []formatters.FormatBatch
+	maxLogDelay       time.Duration
+	truncateOversized bool
+	truncatedMarker   string
 }
 
-func NewTransformer(rawConfig json.RawMessage, formatFns []formatters.FormatEntry, newOutputChunk func() shippers.OutputChunk) (*Transformer, error) {
+func NewTransformer(rawConfig json.RawMessage, formatFns []formatters.FormatBatch, newOutputChunk func() shippers.OutputChunk) (*Transformer, error) {
 	config := struct {
-		MaxLogDelay int `json:"maxLogDelay"`
+		MaxLogDelay       int    `json:"maxLogDelay"`
+		TruncateOversized bool   `json:"truncateOversized"`
+		TruncatedMarker   string `json:"truncatedMarkerPath"`
 	}{
-		MaxLogDelay: 3,
+		MaxLogDelay:       3,
+		TruncateOversized: true,
+		TruncatedMarker:   "truncated",
 	}
 	if err := json.Unmarshal(rawConfig, &config); err != nil {
 		return nil, err
 	}
+	if config.TruncatedMarker == "" {
+		return nil, errors.New("must specify truncatedMarkerPath for transformer")
+	}
 
 	return &Transformer{
-		newOutputChunk: newOutputChunk,
-		formatFns:      formatFns,
-		maxLogDelay:    time.Duration(config.MaxLogDelay) * time.Second,
+		newOutputChunk:    newOutputChunk,
+		formatFns:         formatFns,
+		maxLogDelay:       time.Duration(config.MaxLogDelay) * time.Second,
+		truncateOversized: config.TruncateOversized,
+		truncatedMarker:   config.TruncatedMarker,
 	}, nil
 }
 
@@ -48,6 +64,34 @@ func addChunkID(outputChunk shippers.OutputChunk, id uint64) {
 	}
 }
 
+// format runs a chunk's entries through the formatters, returning
+// what should be shipped: formatters may drop entries (by setting
+// Fields to nil) or emit extra entries, which only go through the
+// formatters after the one that emitted them.
+func (t *Transformer) format(entries []*internal.Entry) []*internal.Entry {
+	for _, formatFn := range t.formatFns {
+		if len(entries) == 0 {
+			break
+		}
+		if err := formatFn(entries); err != nil {
+			// TODO
+			log.Println(err)
+		}
+		results := make([]*internal.Entry, 0, len(entries))
+		for _, entry := range entries {
+			if entry.Emitted != nil {
+				results = append(results, entry.Emitted...)
+				entry.Emitted = nil
+			}
+			if entry.Fields != nil {
+				results = append(results, entry)
+			}
+		}
+		entries = results
+	}
+	return entries
+}
+
 func (t *Transformer) Run(inputChunksChannel chan reader.InputChunk, cursorSaver *reader.CursorSaver, outputChunksChannel chan shippers.OutputChunk) {
 	outputChunk := t.newOutputChunk()
 	lastShipTime := time.Now()
@@ -71,21 +115,7 @@ func (t *Transformer) Run(inputChunksChannel chan reader.InputChunk, cursorSaver
 			}
 		}
 
-		for _, entry := range inputChunk.GetEntries() {
-			for _, formatFn := range t.formatFns {
-				if err := formatFn(entry); err != nil {
-					// TODO
-					log.Println(err)
-				}
-				if entry.Fields == nil {
-					break
-				}
-			}
-
-			if entry.Fields == nil {
-				continue
-			}
-
+		for _, entry := range t.format(inputChunk.GetEntries()) {
 			added, err := outputChunk.Add(entry.Fields)
 			if err != nil {
 				// TODO
@@ -106,12 +136,21 @@ func (t *Transformer) Run(inputChunksChannel chan reader.InputChunk, cursorSaver
 			}
 
 			added, err = outputChunk.Add(entry.Fields)
+			truncated := false
+			// Even an empty chunk can't fit it, so chop up the biggest
+			// fields rather than lose the whole entry.
+			for t.truncateOversized && err == nil && !added && formatters.TruncateLargest(entry.Fields, t.truncatedMarker) {
+				truncated = true
+				added, err = outputChunk.Add(entry.Fields)
+			}
 			if err != nil {
 				// TODO
 				log.Println(err)
 			} else if !added {
-				// TODO
-				log.Println("single log entry too large!")
+				counts.Add("oversizedDropped", 1)
+				log.Println("single log entry too large (dropped)!")
+			} else if truncated {
+				counts.Add("oversizedTruncated", 1)
 			}
 		}
 
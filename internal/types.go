@@ -2,4 +2,17 @@ package internal
 
 type Entry struct {
 	Fields map[string]interface{}
+	// Cursor and BootID say where in the journal this came from (only
+	// set if the reader is configured with entryMetadata).
+	Cursor string
+	BootID string
+	// Emitted holds extra entries a formatter generated while handling
+	// this one (e.g. summaries). They go through the rest of the
+	// formatters and are shipped before this entry.
+	Emitted []*Entry
+}
+
+// Emit adds an extra entry to be shipped.
+func (e *Entry) Emit(fields map[string]interface{}) {
+	e.Emitted = append(e.Emitted, &Entry{Fields: fields})
 }
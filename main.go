@@ -6,6 +6,8 @@ import (
 	"io/ioutil"
 	"log"
 	"math/rand"
+	"net/http"
+	"os"
 	"time"
 
 	"github.com/ghodss/yaml"
@@ -21,6 +23,10 @@ type Config struct {
 	NumTransformers int `json:"numTransformers"`
 	NumShippers     int `json:"numShippers"`
 
+	// MetricsAddress, if set, is where we serve expvar metrics
+	// (/debug/vars).
+	MetricsAddress string `json:"metricsAddress"`
+
 	Reader json.RawMessage `json:"reader"`
 
 	Transformer json.RawMessage   `json:"transformer"`
@@ -35,8 +41,17 @@ type Plugin struct {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "decrypt" {
+		decryptMain(os.Args[2:])
+		return
+	}
+
 	rand.Seed(time.Now().UnixNano())
 	config := loadConfig()
+	if config.MetricsAddress != "" {
+		go serveMetrics(config.MetricsAddress)
+	}
+	checkEntryMetadata(config.Reader, config.Formatters)
 	rdr := configureReader(config.Reader)
 	writer := configureWriter(config.Writer)
 	// We only ever have one shipper because we use journald as our
@@ -94,6 +109,11 @@ func loadConfig() Config {
 	return config
 }
 
+func serveMetrics(address string) {
+	// expvar registers its handler on the default mux.
+	log.Fatal(http.ListenAndServe(address, nil))
+}
+
 func configureShipper(shipperConfig json.RawMessage) shippers.Shipper {
 	var shipperPlugin Plugin
 	if err := json.Unmarshal(shipperConfig, &shipperPlugin); err != nil {
@@ -114,19 +134,26 @@ func configureShipper(shipperConfig json.RawMessage) shippers.Shipper {
 }
 
 func configureTransformer(transformerConfig json.RawMessage, formattersConfig []json.RawMessage, newOutputChunk func() shippers.OutputChunk) *transformer.Transformer {
-	formatFns := make([]formatters.FormatEntry, 0, len(formattersConfig))
+	formatFns := make([]formatters.FormatBatch, 0, len(formattersConfig))
 	for _, formatterConfig := range formattersConfig {
 		var formatterPlugin Plugin
 		if err := json.Unmarshal(formatterConfig, &formatterPlugin); err != nil {
 			log.Fatal(err)
 		}
-		newFormatFn, ok := formatters.Formatters[formatterPlugin.Type]
-		if !ok {
-			log.Fatalf("No such shipper %q", formatterPlugin.Type)
-		}
-		formatFn, err := newFormatFn(formatterConfig)
-		if err != nil {
-			log.Fatal(err)
+		var formatFn formatters.FormatBatch
+		if newFormatFn, ok := formatters.Formatters[formatterPlugin.Type]; ok {
+			formatEntryFn, err := newFormatFn(formatterConfig)
+			if err != nil {
+				log.Fatal(err)
+			}
+			formatFn = formatters.EachEntry(formatEntryFn)
+		} else if newBatchFn, ok := formatters.BatchFormatters[formatterPlugin.Type]; ok {
+			var err error
+			if formatFn, err = newBatchFn(formatterConfig); err != nil {
+				log.Fatal(err)
+			}
+		} else {
+			log.Fatalf("No such formatter %q", formatterPlugin.Type)
 		}
 		formatFns = append(formatFns, formatFn)
 	}
@@ -138,6 +165,22 @@ func configureTransformer(transformerConfig json.RawMessage, formattersConfig []
 	return transformer
 }
 
+// checkEntryMetadata makes sure the reader provides cursors if any
+// formatters need them (rather than them silently doing nothing).
+func checkEntryMetadata(readerConfig json.RawMessage, formattersConfig []json.RawMessage) {
+	var config struct {
+		EntryMetadata bool `json:"entryMetadata"`
+	}
+	if err := json.Unmarshal(readerConfig, &config); err != nil {
+		log.Fatal(err)
+	}
+	for _, formatterConfig := range formattersConfig {
+		if formatters.NeedsEntryMetadata(formatterConfig) && !config.EntryMetadata {
+			log.Fatal("fingerprint formatter needs the reader's entryMetadata option (or fields)")
+		}
+	}
+}
+
 func configureReader(readerConfig json.RawMessage) *reader.Reader {
 	reader, err := reader.NewReader(readerConfig)
 	if err != nil {